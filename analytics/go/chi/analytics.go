@@ -5,86 +5,115 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/tom-draper/api-analytics/analytics/go/core"
 )
 
-// http.ResponseWriter wrapper to store status code
-type responseWriter struct {
-	http.ResponseWriter
-	status      int
-	wroteHeader bool
-}
-
-func createResponseWriter(w http.ResponseWriter) *responseWriter {
-	return &responseWriter{ResponseWriter: w}
-}
-
-func (rw *responseWriter) WriteHeader(code int) {
-	if rw.wroteHeader {
-		return
-	}
-
-	rw.status = code
-	rw.ResponseWriter.WriteHeader(code)
-	rw.wroteHeader = true
-}
-
 type Config struct {
-	PrivacyLevel int
-	ServerURL    string
-	GetPath      func(r *http.Request) string
-	GetHostname  func(r *http.Request) string
-	GetUserAgent func(r *http.Request) string
-	GetIPAddress func(r *http.Request) string
-	GetUserID    func(r *http.Request) string
+	PrivacyLevel         int
+	ServerURL            string
+	Filter               core.Filter // Rules deciding which requests are logged
+	CaptureErrorMessages bool        // Send truncated error and panic messages to the server
+	GetPath              func(r *http.Request) string
+	GetHostname          func(r *http.Request) string
+	GetUserAgent         func(r *http.Request) string
+	GetIPAddress         func(r *http.Request) string
+	GetUserID            func(r *http.Request) string
+	GetTags              func(r *http.Request) map[string]string // Optional low-cardinality key/value tags
 }
 
 func NewConfig() *Config {
 	return &Config{
 		PrivacyLevel: 0,
-		ServerURL: core.DefaultServerURL,
-		GetPath: GetPath,
-		GetHostname: GetHostname,
+		ServerURL:    core.DefaultServerURL,
+		GetPath:      GetPath,
+		GetHostname:  GetHostname,
 		GetUserAgent: GetUserAgent,
 		GetIPAddress: GetIPAddress,
-		GetUserID: GetUserID
+		GetUserID:    GetUserID,
 	}
 }
 
+// Framework is the name reported to the server for requests logged by this
+// middleware.
+const Framework string = "Chi"
+
+// NewClient creates a client to batch and post the requests logged by the
+// middleware. Close the client on shutdown to send any pending requests.
+func NewClient(apiKey string, config *Config) *core.Client {
+	clientConfig := core.NewConfig()
+	clientConfig.PrivacyLevel = config.PrivacyLevel
+	if config.ServerURL != "" {
+		clientConfig.ServerURL = config.ServerURL
+	}
+	return core.NewClient(apiKey, Framework, clientConfig)
+}
+
 func Analytics(apiKey string) func(next http.Handler) http.Handler {
 	return AnalyticsWithConfig(apiKey, &Config{})
 }
 
 func AnalyticsWithConfig(apiKey string, config *Config) func(next http.Handler) http.Handler {
+	return AnalyticsWithClient(NewClient(apiKey, config), config)
+}
+
+func AnalyticsWithClient(client *core.Client, config *Config) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw := core.NewResponseWriter(w) // Wrap to store status code and size
+			var body *core.BodyCounter
+			if r.Body != nil && r.Body != http.NoBody {
+				body = core.NewBodyCounter(r.Body)
+				r.Body = body
+			}
+
+			start := time.Now()
 			defer func() {
-				if err := recover(); err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
+				if rec := recover(); rec != nil {
+					rw.WriteHeader(http.StatusInternalServerError)
+					logRequest(client, config, rw, r, body, start, rec)
 				}
 			}()
+			next.ServeHTTP(rw, r)
+			logRequest(client, config, rw, r, body, start, nil)
+		})
+	}
+}
 
-			ctx := r.Context()
-			rw := createResponseWriter(w) // Wrap to store status code
+func logRequest(client *core.Client, config *Config, rw *core.ResponseWriter, r *http.Request, body *core.BodyCounter, start time.Time, rec any) {
+	elapsed := time.Since(start)
+	data := core.RequestData{
+		Hostname:           getHostname(r, config),
+		IPAddress:          getIPAddress(r, config),
+		Path:               getPath(r, config),
+		UserAgent:          getUserAgent(r, config),
+		Method:             r.Method,
+		Status:             rw.Status(),
+		ResponseTime:       elapsed.Milliseconds(),
+		ResponseTimeMicros: elapsed.Microseconds(),
+		UserID:             getUserID(r, config),
+		CreatedAt:          start.Format(time.RFC3339),
+		RequestSize:        core.RequestSize(r, body),
+		ResponseSize:       rw.Size(),
+		Protocol:           r.Proto,
+		TLS:                r.TLS != nil,
+		Tags:               getTags(r, config),
+	}
 
-			start := time.Now()
-			next.ServeHTTP(rw, r.WithContext(ctx))
-
-			data := core.RequestData{
-				Hostname:     getHostname(r, config),
-				IPAddress:    getIPAddress(r, config),
-				Path:         getPath(r, config),
-				UserAgent:    getUserAgent(r, config),
-				Method:       r.Method,
-				Status:       rw.status,
-				ResponseTime: time.Since(start).Milliseconds(),
-				UserID:       getUserID(r, config),
-				CreatedAt:    start.Format(time.RFC3339),
-			}
+	if rec != nil {
+		if !rw.Written() {
+			data.Status = http.StatusInternalServerError
+		}
+		data.SetError(core.ErrorClassPanic, rec, config.CaptureErrorMessages)
+	} else if class := core.ClassifyError(r.Context(), nil); class != "" {
+		data.SetError(class, nil, false)
+	}
 
-			core.LogRequest(apiKey, data, "Chi", config.PrivacyLevel, config.ServerURL)
-		})
+	if !config.Filter.Apply(&data) {
+		return
 	}
+
+	client.LogRequest(data)
 }
 
 func getHostname(r *http.Request, config *Config) string {
@@ -120,6 +149,13 @@ func getIPAddress(r *http.Request, config *Config) string {
 	return GetIPAddress(r)
 }
 
+func getTags(r *http.Request, config *Config) map[string]string {
+	if config.GetTags != nil {
+		return config.GetTags(r)
+	}
+	return nil
+}
+
 func getUserID(r *http.Request, config *Config) string {
 	if config.GetUserID != nil {
 		return config.GetUserID(r)
@@ -135,6 +171,18 @@ func GetPath(r *http.Request) string {
 	return r.URL.Path
 }
 
+// GetRoutePattern returns the route pattern matched by the router, such as
+// /users/{id}, falling back to the path with IDs replaced by placeholders if
+// no route matched. Assign it to Config.GetPath to group requests by endpoint.
+func GetRoutePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		if pattern := rctx.RoutePattern(); pattern != "" {
+			return pattern
+		}
+	}
+	return core.NormalisePath(r.URL.Path)
+}
+
 func GetUserAgent(r *http.Request) string {
 	return r.UserAgent()
 }
@@ -146,4 +194,4 @@ func GetIPAddress(r *http.Request) string {
 
 func GetUserID(r *http.Request) string {
 	return ""
-}
\ No newline at end of file
+}
@@ -2,4 +2,7 @@ module github.com/tom-draper/api-analytics/analytics/go/chi
 
 go 1.19
 
-require github.com/tom-draper/api-analytics/analytics/go/core v0.0.0-20240603174719-d5fc13e14fb1
+require (
+	github.com/go-chi/chi/v5 v5.0.12
+	github.com/tom-draper/api-analytics/analytics/go/core v0.0.0-20240603174719-d5fc13e14fb1
+)
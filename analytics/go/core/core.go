@@ -1,69 +1,226 @@
 package core
 
 import (
-	"bytes"
-	"encoding/json"
-	"net/http"
+	"context"
+	"io"
+	"sync"
 	"time"
 )
 
-var requests []RequestData
-var lastPosted time.Time = time.Now()
-
 const DefaultServerURL string = "https://www.apianalytics-server.com/"
 
+const (
+	DefaultFlushInterval time.Duration = time.Minute
+	DefaultMaxBatchSize  int           = 1000
+	DefaultMaxRetries    int           = 3
+	DefaultRetryBackoff  time.Duration = time.Second
+	DefaultSpoolMaxBytes int64         = 10 << 20
+)
+
+// Encodings for payloads posted to the server.
+const (
+	EncodingMsgpack string = "msgpack" // Compact binary encoding (default)
+	EncodingJSON    string = "json"
+)
+
 type Payload struct {
 	APIKey       string        `json:"api_key"`
 	Requests     []RequestData `json:"requests"`
 	Framework    string        `json:"framework"`
 	PrivacyLevel int           `json:"privacy_level"`
+	BatchID      string        `json:"batch_id,omitempty"` // Identifies a batch across retries, for the server to discard duplicates
 }
 
 type RequestData struct {
-	Hostname     string `json:"hostname"`
-	IPAddress    string `json:"ip_address"`
-	Path         string `json:"path"`
-	UserAgent    string `json:"user_agent"`
-	Method       string `json:"method"`
-	ResponseTime int64  `json:"response_time"`
-	Status       int    `json:"status"`
-	UserID       string `json:"user_id"`
-	CreatedAt    string `json:"created_at"`
+	Hostname           string            `json:"hostname"`
+	IPAddress          string            `json:"ip_address"`
+	Path               string            `json:"path"`
+	UserAgent          string            `json:"user_agent"`
+	Method             string            `json:"method"`
+	ResponseTime       int64             `json:"response_time"` // Milliseconds, for servers without microsecond support
+	ResponseTimeMicros int64             `json:"response_time_us"`
+	Status             int               `json:"status"`
+	UserID             string            `json:"user_id"`
+	CreatedAt          string            `json:"created_at"`
+	Weight             float64           `json:"weight,omitempty"` // Number of requests represented when sampled
+	RequestSize        int64             `json:"request_size"`     // Request body size in bytes
+	ResponseSize       int64             `json:"response_size"`    // Response body size in bytes
+	Protocol           string            `json:"protocol"`         // HTTP version, e.g. HTTP/1.1
+	TLS                bool              `json:"tls"`
+	Tags               map[string]string `json:"tags,omitempty"` // Custom key/value tags
+	ErrorClass         string            `json:"error_class,omitempty"`
+	ErrorMessage       string            `json:"error_message,omitempty"` // Only sent if error messages are captured
+	Sequence           int               `json:"seq,omitempty"`           // Position within the uploaded batch from 1, set by the exporter
 }
 
-func getServerEndpoint(serverURL string) {
-	if serverURL = "" {
-		return DefaultServerUrl + "api/log-request"
-	}
-	if serverURL.HasSuffix("/") {
-		return serverURL + "api/log-request"
+type Config struct {
+	PrivacyLevel  int
+	ServerURL     string
+	FlushInterval time.Duration             // Maximum time a logged request waits before being posted
+	MaxBatchSize  int                       // Number of buffered requests that triggers an early flush
+	MaxRetries    int                       // Further attempts made after a batch fails to upload
+	RetryBackoff  time.Duration             // Delay before the first retry, doubled after each attempt
+	SpoolDir      string                    // Optional directory to store batches that could not be uploaded
+	SpoolMaxBytes int64                     // Maximum total size of the spool directory
+	Exporter      Exporter                  // Destination for batches, defaults to the API Analytics server
+	OnIngest      func(result IngestResult) // Optional callback with the server's report of each uploaded batch
+	Encoding      string                    // Payload encoding, EncodingMsgpack or EncodingJSON
+	NoCompression bool                      // Disable gzip compression of payloads
+}
+
+func NewConfig() *Config {
+	return &Config{
+		PrivacyLevel:  0,
+		ServerURL:     DefaultServerURL,
+		FlushInterval: DefaultFlushInterval,
+		MaxBatchSize:  DefaultMaxBatchSize,
+		MaxRetries:    DefaultMaxRetries,
+		RetryBackoff:  DefaultRetryBackoff,
+		SpoolMaxBytes: DefaultSpoolMaxBytes,
+		Encoding:      EncodingMsgpack,
 	}
-	return serverURL + "/api/log-request"
 }
 
-func postRequest(apiKey string, requests []RequestData, framework string, privacyLevel int, serverURL string) {
-	data := Payload{
-		APIKey:       apiKey,
-		Requests:     requests,
-		Framework:    framework,
-		PrivacyLevel: privacyLevel,
+// Client buffers logged requests and passes them to an exporter in batches
+// from a background goroutine. It is safe for concurrent use by multiple
+// handlers.
+type Client struct {
+	config   Config
+	exporter Exporter
+
+	mu       sync.Mutex
+	requests []RequestData
+	closed   bool
+
+	flush  chan struct{}
+	done   chan struct{}
+	wg     sync.WaitGroup
+	ctx    context.Context // Context of background exports, cancelled if Close times out
+	cancel context.CancelFunc
+}
+
+func NewClient(apiKey string, framework string, config *Config) *Client {
+	if config == nil {
+		config = NewConfig()
+	}
+	c := &Client{
+		config: *config,
+		flush:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	if c.config.FlushInterval <= 0 {
+		c.config.FlushInterval = DefaultFlushInterval
+	}
+	if c.config.MaxBatchSize <= 0 {
+		c.config.MaxBatchSize = DefaultMaxBatchSize
 	}
-	body, err := json.Marshal(data)
-	if err == nil {
-		url := getServerEndpoint(serverURL)
-		http.Post(url, "application/json", bytes.NewBuffer(body))
+	c.exporter = c.config.Exporter
+	if c.exporter == nil {
+		c.exporter = NewHTTPExporter(apiKey, framework, &c.config)
 	}
+
+	c.wg.Add(1)
+	go c.run()
+	return c
 }
 
-func LogRequest(apiKey string, request RequestData, framework string, privacyLevel int, serverURL string) {
-	if apiKey == "" {
+func (c *Client) run() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.Flush(c.ctx)
+		case <-c.flush:
+			c.Flush(c.ctx)
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// LogRequest adds a request to the buffer, waking the background goroutine
+// early if the buffer has reached its maximum batch size.
+func (c *Client) LogRequest(request RequestData) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
 		return
 	}
-	now := time.Now()
-	requests = append(requests, request)
-	if time.Since(lastPosted) > time.Minute {
-		go postRequest(apiKey, requests, framework, privacyLevel, serverURL)
-		requests = nil
-		lastPosted = now
+	c.requests = append(c.requests, request)
+	full := len(c.requests) >= c.config.MaxBatchSize
+	c.mu.Unlock()
+
+	if full {
+		select {
+		case c.flush <- struct{}{}:
+		default: // Flush already pending
+		}
+	}
+}
+
+// Flush exports all currently buffered requests, blocking until the export
+// completes or ctx is done.
+func (c *Client) Flush(ctx context.Context) error {
+	c.mu.Lock()
+	requests := c.requests
+	c.requests = nil
+	c.mu.Unlock()
+
+	if len(requests) == 0 {
+		return nil
+	}
+	return c.exporter.Export(ctx, requests)
+}
+
+// Close stops the background goroutine, drains any pending requests and closes
+// the exporter if it holds resources. Requests logged after Close are
+// discarded. If ctx is done before the background export in progress returns,
+// that export is cancelled, pending requests are dropped and ctx.Err() is
+// returned.
+func (c *Client) Close(ctx context.Context) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	close(c.done)
+	stopped := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		// Exporter closed once the cancelled export returns
+		c.cancel()
+		go func() {
+			<-stopped
+			c.closeExporter()
+		}()
+		return ctx.Err()
+	}
+	defer c.cancel()
+
+	err := c.Flush(ctx)
+	if closeErr := c.closeExporter(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (c *Client) closeExporter() error {
+	if closer, ok := c.exporter.(io.Closer); ok {
+		return closer.Close()
 	}
+	return nil
 }
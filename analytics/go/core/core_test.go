@@ -0,0 +1,445 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	payloads []Payload
+}
+
+func newTestServer() *testServer {
+	s := &testServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload Payload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.payloads = append(s.payloads, payload)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	return s
+}
+
+func (s *testServer) requestCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for _, payload := range s.payloads {
+		count += len(payload.Requests)
+	}
+	return count
+}
+
+func TestCloseDrainsPendingRequests(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	config := NewConfig()
+	config.ServerURL = server.URL
+	client := NewClient("test", "Gin", config)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.LogRequest(RequestData{Path: "/", Method: "GET", Status: 200})
+		}()
+	}
+	wg.Wait()
+
+	if err := client.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.requestCount(); got != 50 {
+		t.Errorf("got %d requests, expected 50", got)
+	}
+
+	// Requests logged after close are discarded
+	client.LogRequest(RequestData{Path: "/", Method: "GET", Status: 200})
+	if err := client.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.requestCount(); got != 50 {
+		t.Errorf("got %d requests after close, expected 50", got)
+	}
+}
+
+// blockingExporter blocks each export until its context is done.
+type blockingExporter struct {
+	started   chan struct{}
+	cancelled chan struct{}
+}
+
+func (e *blockingExporter) Export(ctx context.Context, requests []RequestData) error {
+	close(e.started)
+	<-ctx.Done()
+	close(e.cancelled)
+	return ctx.Err()
+}
+
+func TestCloseTimeout(t *testing.T) {
+	exporter := &blockingExporter{started: make(chan struct{}), cancelled: make(chan struct{})}
+	config := NewConfig()
+	config.Exporter = exporter
+	config.MaxBatchSize = 1
+	client := NewClient("test", "Gin", config)
+
+	client.LogRequest(RequestData{Path: "/", Method: "GET", Status: 200})
+	<-exporter.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := client.Close(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got %v, expected %v", err, context.DeadlineExceeded)
+	}
+
+	// Background export cancelled
+	select {
+	case <-exporter.cancelled:
+	case <-time.After(2 * time.Second):
+		t.Error("background export not cancelled")
+	}
+}
+
+func TestFlushWhenBatchFull(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	config := NewConfig()
+	config.ServerURL = server.URL
+	config.MaxBatchSize = 10
+	client := NewClient("test", "Gin", config)
+	defer client.Close(context.Background())
+
+	for i := 0; i < 10; i++ {
+		client.LogRequest(RequestData{Path: "/", Method: "GET", Status: 200})
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for server.requestCount() < 10 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := server.requestCount(); got != 10 {
+		t.Errorf("got %d requests, expected 10", got)
+	}
+}
+
+func TestRetryAfterTooManyRequests(t *testing.T) {
+	var attempts int
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	config := NewConfig()
+	config.ServerURL = server.URL
+	config.RetryBackoff = time.Millisecond
+	client := NewClient("test", "Gin", config)
+	defer client.Close(context.Background())
+
+	client.LogRequest(RequestData{Path: "/", Method: "GET", Status: 200})
+	if err := client.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 {
+		t.Errorf("got %d attempts, expected 3", attempts)
+	}
+}
+
+func TestBatchIDReusedOnRetry(t *testing.T) {
+	var mu sync.Mutex
+	var payloads []Payload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload Payload
+		json.NewDecoder(r.Body).Decode(&payload)
+		mu.Lock()
+		defer mu.Unlock()
+		payloads = append(payloads, payload)
+		if len(payloads) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	config := NewConfig()
+	config.ServerURL = server.URL
+	config.RetryBackoff = time.Millisecond
+	client := NewClient("test", "Gin", config)
+	defer client.Close(context.Background())
+
+	for i := 0; i < 2; i++ {
+		client.LogRequest(RequestData{Path: "/", Method: "GET", Status: 200})
+		client.LogRequest(RequestData{Path: "/users", Method: "GET", Status: 200})
+		if err := client.Flush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(payloads) != 3 {
+		t.Fatalf("got %d uploads, expected 3", len(payloads))
+	}
+	if payloads[0].BatchID == "" || payloads[0].BatchID != payloads[1].BatchID {
+		t.Errorf("got batch IDs %q and %q, expected the same ID when retried", payloads[0].BatchID, payloads[1].BatchID)
+	}
+	if payloads[2].BatchID == payloads[0].BatchID {
+		t.Errorf("got batch ID %q reused for a new batch", payloads[2].BatchID)
+	}
+	for i, request := range payloads[1].Requests {
+		if request.Sequence != i+1 {
+			t.Errorf("%d: got sequence %d, expected %d", i, request.Sequence, i+1)
+		}
+	}
+}
+
+func TestNoRetryOnBadRequest(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	config := NewConfig()
+	config.ServerURL = server.URL
+	config.RetryBackoff = time.Millisecond
+	config.SpoolDir = t.TempDir()
+	client := NewClient("test", "Gin", config)
+	defer client.Close(context.Background())
+
+	client.LogRequest(RequestData{Path: "/", Method: "GET", Status: 200})
+	if err := client.Flush(context.Background()); !permanent(err) {
+		t.Fatalf("got %v, expected permanent error", err)
+	}
+	if attempts != 1 {
+		t.Errorf("got %d attempts, expected 1", attempts)
+	}
+	if files, _ := client.exporter.(*HTTPExporter).spool.files(); len(files) != 0 {
+		t.Errorf("got %d spooled batches, expected 0", len(files))
+	}
+}
+
+func TestIngestResultReported(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"status": 202, "accepted": 1, "rejected": 1, "rejections": {"invalid_method": {"count": 1, "indexes": [1]}}}`))
+	}))
+	defer server.Close()
+
+	var results []IngestResult
+	config := NewConfig()
+	config.ServerURL = server.URL
+	config.OnIngest = func(result IngestResult) {
+		results = append(results, result)
+	}
+	client := NewClient("test", "Gin", config)
+	defer client.Close(context.Background())
+
+	client.LogRequest(RequestData{Path: "/", Method: "GET", Status: 200})
+	client.LogRequest(RequestData{Path: "/", Method: "INVALID", Status: 200})
+	if err := client.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, expected 1", len(results))
+	}
+	rejection := results[0].Rejections[RejectInvalidMethod]
+	if results[0].Accepted != 1 || results[0].Rejected != 1 || rejection.Count != 1 || len(rejection.Indexes) != 1 || rejection.Indexes[0] != 1 {
+		t.Errorf("got %+v, expected 1 accepted and request 1 rejected for invalid method", results[0])
+	}
+}
+
+func TestPayloadFormatNegotiated(t *testing.T) {
+	var mu sync.Mutex
+	var formats []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		formats = append(formats, r.Header.Get("Content-Type")+" "+r.Header.Get("Content-Encoding"))
+		mu.Unlock()
+		w.Header().Set("Accept-Post", "application/json, application/msgpack")
+		w.Header().Set("Accept-Encoding", "gzip, zstd")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	config := NewConfig()
+	config.ServerURL = server.URL
+	client := NewClient("test", "Gin", config)
+	defer client.Close(context.Background())
+
+	for i := 0; i < 2; i++ {
+		client.LogRequest(RequestData{Path: "/", Method: "GET", Status: 200})
+		if err := client.Flush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// JSON sent until the server advertises support for the compact format
+	expected := []string{"application/json ", "application/msgpack gzip"}
+	if !reflect.DeepEqual(formats, expected) {
+		t.Errorf("got %q, expected %q", formats, expected)
+	}
+}
+
+func TestSpoolReplayedAfterSuccess(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	var mu sync.Mutex
+	down := true
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		isDown := down
+		mu.Unlock()
+		if isDown {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		server.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	config := NewConfig()
+	config.ServerURL = proxy.URL
+	config.MaxRetries = 0
+	config.SpoolDir = t.TempDir()
+	client := NewClient("test", "Gin", config)
+	defer client.Close(context.Background())
+
+	for i := 0; i < 3; i++ {
+		client.LogRequest(RequestData{Path: "/", Method: "GET", Status: 200})
+		if err := client.Flush(context.Background()); err == nil {
+			t.Fatal("expected error while server down")
+		}
+	}
+	if files, _ := client.exporter.(*HTTPExporter).spool.files(); len(files) != 3 {
+		t.Fatalf("got %d spooled batches, expected 3", len(files))
+	}
+
+	mu.Lock()
+	down = false
+	mu.Unlock()
+
+	client.LogRequest(RequestData{Path: "/", Method: "GET", Status: 200})
+	if err := client.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.requestCount(); got != 4 {
+		t.Errorf("got %d requests, expected 4", got)
+	}
+	if files, _ := client.exporter.(*HTTPExporter).spool.files(); len(files) != 0 {
+		t.Errorf("got %d spooled batches, expected 0", len(files))
+	}
+}
+
+func TestSpoolSizeLimit(t *testing.T) {
+	s := newSpool(t.TempDir(), 100)
+	for i := 0; i < 5; i++ {
+		if err := s.write(make([]byte, 40)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, _ := s.files()
+	if len(files) != 2 {
+		t.Errorf("got %d spooled batches, expected 2", len(files))
+	}
+	if err := s.write(make([]byte, 101)); err == nil {
+		t.Error("expected error for batch larger than spool limit")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("5"); got != 5*time.Second {
+		t.Errorf("got %s, expected 5s", got)
+	}
+	if got := parseRetryAfter(""); got != 0 {
+		t.Errorf("got %s, expected 0", got)
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(date); got <= 0 || got > time.Minute {
+		t.Errorf("got %s, expected up to 1m", got)
+	}
+}
+
+func TestMemoryExporter(t *testing.T) {
+	exporter := NewMemoryExporter()
+	config := NewConfig()
+	config.Exporter = exporter
+	client := NewClient("", "Gin", config)
+
+	client.LogRequest(RequestData{Path: "/users", Method: "GET", Status: 200})
+	client.LogRequest(RequestData{Path: "/users", Method: "POST", Status: 201})
+	if err := client.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := exporter.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, expected 2", len(requests))
+	}
+	if requests[1].Method != "POST" {
+		t.Errorf("got method %s, expected POST", requests[1].Method)
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.jsonl")
+	exporter, err := NewFileExporter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	config.Exporter = exporter
+	client := NewClient("", "Gin", config)
+
+	for i := 0; i < 3; i++ {
+		client.LogRequest(RequestData{Path: "/", Method: "GET", Status: 200})
+	}
+	if err := client.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var request RequestData
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			t.Fatal(err)
+		}
+		lines++
+	}
+	if lines != 3 {
+		t.Errorf("got %d lines, expected 3", lines)
+	}
+}
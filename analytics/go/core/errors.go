@@ -0,0 +1,68 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Error classes recorded for requests that did not complete normally.
+const (
+	ErrorClassPanic        string = "panic"
+	ErrorClassTimeout      string = "timeout"
+	ErrorClassHandlerError string = "handler_error"
+	ErrorClassClientCancel string = "client_cancel"
+)
+
+// MaxErrorMessageLength is the number of bytes of an error message sent to
+// the server when error messages are captured.
+const MaxErrorMessageLength int = 256
+
+// ClassifyError returns the error class for a request handled with ctx that
+// returned err, or an empty string if the request completed normally.
+func ClassifyError(ctx context.Context, err error) string {
+	var timeout interface{ Timeout() bool }
+	switch {
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &timeout) && timeout.Timeout():
+		return ErrorClassTimeout
+	case errors.Is(err, context.Canceled):
+		return ErrorClassClientCancel
+	case err != nil:
+		return ErrorClassHandlerError
+	}
+
+	if ctx != nil {
+		switch ctx.Err() {
+		case context.DeadlineExceeded:
+			return ErrorClassTimeout
+		case context.Canceled:
+			return ErrorClassClientCancel
+		}
+	}
+	return ""
+}
+
+// SetError records the error class of the request and, if captureMessage is
+// set, the error message truncated to MaxErrorMessageLength. The value may be
+// an error or a value recovered from a panic.
+func (r *RequestData) SetError(class string, value any, captureMessage bool) {
+	r.ErrorClass = class
+	if !captureMessage || value == nil {
+		return
+	}
+	r.ErrorMessage = truncateMessage(fmt.Sprint(value), MaxErrorMessageLength)
+}
+
+// truncateMessage shortens a message to at most n bytes without splitting a
+// multi-byte character.
+func truncateMessage(message string, n int) string {
+	if len(message) <= n {
+		return message
+	}
+	for n > 0 && !utf8.RuneStart(message[n]) {
+		n--
+	}
+	return message[:n]
+}
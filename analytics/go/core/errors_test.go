@@ -0,0 +1,50 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		ctx      context.Context
+		err      error
+		expected string
+	}{
+		{context.Background(), nil, ""},
+		{nil, nil, ""},
+		{context.Background(), errors.New("not found"), ErrorClassHandlerError},
+		{context.Background(), fmt.Errorf("query: %w", context.DeadlineExceeded), ErrorClassTimeout},
+		{context.Background(), &net.DNSError{IsTimeout: true}, ErrorClassTimeout},
+		{context.Background(), context.Canceled, ErrorClassClientCancel},
+		{cancelled, nil, ErrorClassClientCancel},
+	}
+
+	for i, test := range tests {
+		if got := ClassifyError(test.ctx, test.err); got != test.expected {
+			t.Errorf("%d: got %q, expected %q", i, got, test.expected)
+		}
+	}
+}
+
+func TestSetError(t *testing.T) {
+	var data RequestData
+	data.SetError(ErrorClassPanic, "boom", false)
+	if data.ErrorClass != ErrorClassPanic || data.ErrorMessage != "" {
+		t.Errorf("got %q %q, expected panic without message", data.ErrorClass, data.ErrorMessage)
+	}
+
+	// Truncated without splitting the multi-byte character at the limit
+	message := strings.Repeat("a", MaxErrorMessageLength-1) + "é"
+	data.SetError(ErrorClassHandlerError, errors.New(message), true)
+	if expected := message[:MaxErrorMessageLength-1]; data.ErrorMessage != expected {
+		t.Errorf("got %d bytes, expected %d", len(data.ErrorMessage), len(expected))
+	}
+}
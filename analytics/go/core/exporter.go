@@ -0,0 +1,98 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// Exporter receives batches of logged requests from a Client. Exporters that
+// hold resources may also implement io.Closer to be closed with the client.
+type Exporter interface {
+	Export(ctx context.Context, requests []RequestData) error
+}
+
+// WriterExporter writes each request to an io.Writer as a line of JSON.
+type WriterExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+// NewStdoutExporter creates an exporter that writes requests to standard
+// output as JSON lines.
+func NewStdoutExporter() *WriterExporter {
+	return NewWriterExporter(os.Stdout)
+}
+
+func (e *WriterExporter) Export(ctx context.Context, requests []RequestData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	encoder := json.NewEncoder(e.w)
+	for _, request := range requests {
+		if err := encoder.Encode(request); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FileExporter appends requests to a local JSON lines file.
+type FileExporter struct {
+	*WriterExporter
+	file *os.File
+}
+
+func NewFileExporter(path string) (*FileExporter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{WriterExporter: NewWriterExporter(file), file: file}, nil
+}
+
+func (e *FileExporter) Close() error {
+	return e.file.Close()
+}
+
+// MemoryExporter keeps exported requests in memory, allowing tests to assert
+// on the requests logged by a middleware without a server.
+type MemoryExporter struct {
+	mu       sync.Mutex
+	requests []RequestData
+}
+
+func NewMemoryExporter() *MemoryExporter {
+	return &MemoryExporter{}
+}
+
+func (e *MemoryExporter) Export(ctx context.Context, requests []RequestData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.requests = append(e.requests, requests...)
+	return nil
+}
+
+// Requests returns a copy of all requests exported so far.
+func (e *MemoryExporter) Requests() []RequestData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	requests := make([]RequestData, len(e.requests))
+	copy(requests, e.requests)
+	return requests
+}
+
+func (e *MemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.requests = nil
+}
@@ -0,0 +1,111 @@
+package core
+
+import (
+	"hash/fnv"
+	"math"
+	"path"
+	"strings"
+)
+
+// Filter decides which requests are logged by a middleware. The zero value
+// logs every request.
+type Filter struct {
+	IncludePaths  []string // Path globs to log, all paths if empty
+	ExcludePaths  []string // Path globs to never log, taking priority over IncludePaths
+	Methods       []string // Methods to log, all methods if empty
+	StatusClasses []int    // Status classes to log (e.g. 5 for 5xx), all statuses if empty
+	SampleRate    float64  // Fraction of matching requests to log, all requests if 0 or 1
+}
+
+// Apply reports whether request should be logged. Requests kept by sampling
+// have their weight set to the number of requests they represent.
+func (f *Filter) Apply(request *RequestData) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.IncludePaths) > 0 && !matchAnyGlob(f.IncludePaths, request.Path) {
+		return false
+	}
+	if matchAnyGlob(f.ExcludePaths, request.Path) {
+		return false
+	}
+	if len(f.Methods) > 0 && !containsMethod(f.Methods, request.Method) {
+		return false
+	}
+	if len(f.StatusClasses) > 0 && !containsStatusClass(f.StatusClasses, request.Status) {
+		return false
+	}
+	if f.SampleRate > 0 && f.SampleRate < 1 {
+		if sampleFraction(request) >= f.SampleRate {
+			return false
+		}
+		request.Weight = 1 / f.SampleRate
+	}
+	return true
+}
+
+func containsMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsStatusClass(classes []int, status int) bool {
+	for _, class := range classes {
+		if status/100 == class {
+			return true
+		}
+	}
+	return false
+}
+
+// sampleFraction hashes the request into [0, 1) so the same request always
+// receives the same sampling decision.
+func sampleFraction(request *RequestData) float64 {
+	h := fnv.New64a()
+	for _, value := range []string{request.Method, request.Path, request.CreatedAt, request.IPAddress, request.UserAgent, request.UserID} {
+		h.Write([]byte(value))
+		h.Write([]byte{0})
+	}
+	return float64(h.Sum64()) / (math.MaxUint64 + 1.0)
+}
+
+func matchAnyGlob(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches a path against a glob pattern in which * matches within a
+// single path segment and ** matches any number of segments.
+func matchGlob(pattern string, value string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(value, "/"))
+}
+
+func matchSegments(pattern []string, value []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(value); i++ {
+				if matchSegments(pattern[1:], value[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(value) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], value[0]); err != nil || !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		value = value[1:]
+	}
+	return len(value) == 0
+}
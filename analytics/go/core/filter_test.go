@@ -0,0 +1,83 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		path     string
+		expected bool
+	}{
+		{"/health", "/health", true},
+		{"/health", "/healthz", false},
+		{"/static/*", "/static/app.js", true},
+		{"/static/*", "/static/css/app.css", false},
+		{"/static/**", "/static/css/app.css", true},
+		{"/static/**", "/static", true},
+		{"/users/*/posts", "/users/123/posts", true},
+		{"/**/*.png", "/images/icons/logo.png", true},
+		{"/**/*.png", "/images/logo.jpg", false},
+	}
+
+	for _, test := range tests {
+		if got := matchGlob(test.pattern, test.path); got != test.expected {
+			t.Errorf("%s %s: got %t, expected %t", test.pattern, test.path, got, test.expected)
+		}
+	}
+}
+
+func TestFilterApply(t *testing.T) {
+	filter := Filter{
+		IncludePaths:  []string{"/api/**"},
+		ExcludePaths:  []string{"/api/health"},
+		Methods:       []string{"GET", "POST"},
+		StatusClasses: []int{2, 5},
+	}
+
+	tests := []struct {
+		request  RequestData
+		expected bool
+	}{
+		{RequestData{Path: "/api/users", Method: "GET", Status: 200}, true},
+		{RequestData{Path: "/api/users", Method: "post", Status: 503}, true},
+		{RequestData{Path: "/api/health", Method: "GET", Status: 200}, false},
+		{RequestData{Path: "/static/app.js", Method: "GET", Status: 200}, false},
+		{RequestData{Path: "/api/users", Method: "DELETE", Status: 200}, false},
+		{RequestData{Path: "/api/users", Method: "GET", Status: 404}, false},
+	}
+
+	for i, test := range tests {
+		if got := filter.Apply(&test.request); got != test.expected {
+			t.Errorf("%d: got %t, expected %t", i, got, test.expected)
+		}
+	}
+}
+
+func TestFilterSampleRate(t *testing.T) {
+	filter := Filter{SampleRate: 0.25}
+
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		request := RequestData{Path: fmt.Sprintf("/users/%d", i), Method: "GET", Status: 200}
+		if !filter.Apply(&request) {
+			continue
+		}
+		kept++
+		if request.Weight != 4 {
+			t.Fatalf("got weight %f, expected 4", request.Weight)
+		}
+
+		// Decision is deterministic for the same request
+		again := RequestData{Path: request.Path, Method: "GET", Status: 200}
+		if !filter.Apply(&again) {
+			t.Fatalf("%s: sampling decision not deterministic", request.Path)
+		}
+	}
+
+	if kept < 2000 || kept > 3000 {
+		t.Errorf("kept %d of 10000 requests, expected around 2500", kept)
+	}
+}
@@ -0,0 +1,246 @@
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// HTTPExporter posts batches to the log-request endpoint of an API Analytics
+// server. Batches are sent as gzip-compressed MessagePack by default once the
+// server advertises support for them, and as JSON until then or if the server
+// stops accepting them.
+type HTTPExporter struct {
+	apiKey       string
+	framework    string
+	privacyLevel int
+	serverURL    string
+	maxRetries   int
+	retryBackoff time.Duration
+	httpClient   *http.Client
+	spool        *spool // Nil if spooling disabled
+	onIngest     func(result IngestResult)
+	format       payloadFormat // Preferred format, used once the server accepts it
+	msgpack      atomic.Bool   // Whether the server has advertised MessagePack support
+	gzip         atomic.Bool   // Whether the server has advertised gzip support
+}
+
+// payloadFormat describes how a payload is encoded in the request body.
+type payloadFormat struct {
+	contentType string
+	gzip        bool
+}
+
+// Format understood by all servers, used for spooled batches
+var jsonFormat = payloadFormat{contentType: "application/json"}
+
+// NewHTTPExporter creates an exporter using the server URL, retry and spool
+// settings from config. Any batches spooled by a previous run are replayed in
+// the background.
+func NewHTTPExporter(apiKey string, framework string, config *Config) *HTTPExporter {
+	if config == nil {
+		config = NewConfig()
+	}
+	e := &HTTPExporter{
+		apiKey:       apiKey,
+		framework:    framework,
+		privacyLevel: config.PrivacyLevel,
+		serverURL:    config.ServerURL,
+		maxRetries:   config.MaxRetries,
+		retryBackoff: config.RetryBackoff,
+		httpClient:   &http.Client{Timeout: 30 * time.Second},
+		onIngest:     config.OnIngest,
+		format:       payloadFormat{contentType: "application/msgpack", gzip: !config.NoCompression},
+	}
+	if config.Encoding == EncodingJSON {
+		e.format.contentType = "application/json"
+	}
+	if e.maxRetries < 0 {
+		e.maxRetries = 0
+	}
+	if e.retryBackoff <= 0 {
+		e.retryBackoff = DefaultRetryBackoff
+	}
+	if config.SpoolDir != "" {
+		maxBytes := config.SpoolMaxBytes
+		if maxBytes <= 0 {
+			maxBytes = DefaultSpoolMaxBytes
+		}
+		e.spool = newSpool(config.SpoolDir, maxBytes)
+		go e.replaySpool(context.Background())
+	}
+	return e
+}
+
+func getServerEndpoint(serverURL string) string {
+	if serverURL == "" {
+		return DefaultServerURL + "api/log-request"
+	}
+	if strings.HasSuffix(serverURL, "/") {
+		return serverURL + "api/log-request"
+	}
+	return serverURL + "/api/log-request"
+}
+
+// Export uploads a batch, retrying on network errors, 429 and 5xx responses.
+// A batch that still fails is written to the spool, if enabled, to be
+// replayed after the next successful upload.
+func (e *HTTPExporter) Export(ctx context.Context, requests []RequestData) error {
+	if e.apiKey == "" || len(requests) == 0 {
+		return nil
+	}
+
+	// Number the requests so the server can discard those it has already
+	// stored if the batch is sent again after an ambiguous failure
+	sequenced := make([]RequestData, len(requests))
+	copy(sequenced, requests)
+	// Numbered from 1 so that every request sends its sequence
+	for i := range sequenced {
+		sequenced[i].Sequence = i + 1
+	}
+	data := Payload{
+		APIKey:       e.apiKey,
+		Requests:     sequenced,
+		Framework:    e.framework,
+		PrivacyLevel: e.privacyLevel,
+		BatchID:      newBatchID(),
+	}
+	format := e.negotiatedFormat()
+	body, err := encodePayload(data, format)
+	if err != nil {
+		return err
+	}
+
+	err = e.sendWithRetry(ctx, body, format)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusUnsupportedMediaType && format != jsonFormat {
+		// Server no longer accepts the compact format
+		e.msgpack.Store(false)
+		e.gzip.Store(false)
+		format = jsonFormat
+		if body, err = encodePayload(data, format); err != nil {
+			return err
+		}
+		err = e.sendWithRetry(ctx, body, format)
+	}
+	if err == nil {
+		e.replaySpool(ctx)
+		return nil
+	}
+	if e.spool != nil && !permanent(err) {
+		// Spool as JSON so that batches can be replayed to any server
+		if format != jsonFormat {
+			var encodeErr error
+			if body, encodeErr = encodePayload(data, jsonFormat); encodeErr != nil {
+				return err
+			}
+		}
+		if spoolErr := e.spool.write(body); spoolErr != nil {
+			return fmt.Errorf("%w (spool failed: %v)", err, spoolErr)
+		}
+	}
+	return err
+}
+
+// negotiatedFormat returns the preferred payload format, limited to what the
+// server has advertised support for. Payloads are sent as uncompressed JSON
+// until the first response from the server.
+func (e *HTTPExporter) negotiatedFormat() payloadFormat {
+	format := jsonFormat
+	if e.format.contentType == "application/msgpack" && e.msgpack.Load() {
+		format.contentType = "application/msgpack"
+	}
+	format.gzip = e.format.gzip && e.gzip.Load()
+	return format
+}
+
+// negotiate records the payload formats advertised by the server in the
+// Accept-Post and Accept-Encoding response headers.
+func (e *HTTPExporter) negotiate(header http.Header) {
+	if accept := header.Get("Accept-Post"); accept != "" {
+		e.msgpack.Store(strings.Contains(accept, "application/msgpack"))
+	}
+	if accept := header.Get("Accept-Encoding"); accept != "" {
+		e.gzip.Store(strings.Contains(accept, "gzip"))
+	}
+}
+
+// newBatchID returns a random version 4 UUID.
+func newBatchID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "" // Sent without deduplication
+	}
+	b[6] = b[6]&0x0f | 0x40 // Version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func encodePayload(payload Payload, format payloadFormat) ([]byte, error) {
+	var body []byte
+	if format.contentType == "application/msgpack" {
+		body = encodeMsgpack(payload)
+	} else {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+	if !format.gzip {
+		return body, nil
+	}
+
+	var buffer bytes.Buffer
+	gzw := gzip.NewWriter(&buffer)
+	if _, err := gzw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := gzw.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (e *HTTPExporter) send(ctx context.Context, body []byte, format payloadFormat) error {
+	url := getServerEndpoint(e.serverURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", format.contentType)
+	if format.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	response, err := e.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	e.negotiate(response.Header)
+
+	// Report rejected requests, including when the whole batch was rejected
+	if e.onIngest != nil {
+		if result, ok := readIngestResult(response.Body); ok {
+			e.onIngest(result)
+		}
+	}
+	io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode >= 300 {
+		return &StatusError{
+			StatusCode: response.StatusCode,
+			RetryAfter: parseRetryAfter(response.Header.Get("Retry-After")),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Reasons a logged request may be rejected by the server.
+const (
+	RejectInvalidMethod    string = "invalid_method"
+	RejectInvalidUserAgent string = "invalid_user_agent"
+	RejectInvalidUserID    string = "invalid_user_id"
+	RejectInvalidHostname  string = "invalid_hostname"
+	RejectInvalidPath      string = "invalid_path"
+	RejectInvalidDate      string = "invalid_date"
+	RejectOverLimit        string = "over_limit" // Beyond the maximum requests accepted in one upload
+)
+
+// IngestResult is the server's report of the requests it accepted from an
+// uploaded batch.
+type IngestResult struct {
+	Accepted   int                  `json:"accepted"`
+	Rejected   int                  `json:"rejected"`
+	Duplicates int                  `json:"duplicates"` // Already stored from an earlier upload of the batch
+	Rejections map[string]Rejection `json:"rejections"` // Keyed by reason
+}
+
+// Rejection counts the requests rejected for a reason, with a sample of
+// their indexes within the batch.
+type Rejection struct {
+	Count   int   `json:"count"`
+	Indexes []int `json:"indexes"`
+}
+
+// readIngestResult decodes the ingest result from a server response body,
+// returning false if the server did not report one.
+func readIngestResult(body io.Reader) (IngestResult, bool) {
+	var response struct {
+		Accepted   *int                 `json:"accepted"`
+		Rejected   int                  `json:"rejected"`
+		Duplicates int                  `json:"duplicates"`
+		Rejections map[string]Rejection `json:"rejections"`
+	}
+	if err := json.NewDecoder(io.LimitReader(body, 1<<20)).Decode(&response); err != nil || response.Accepted == nil {
+		return IngestResult{}, false
+	}
+	return IngestResult{
+		Accepted:   *response.Accepted,
+		Rejected:   response.Rejected,
+		Duplicates: response.Duplicates,
+		Rejections: response.Rejections,
+	}, true
+}
@@ -0,0 +1,182 @@
+package core
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// msgpackWriter encodes the subset of MessagePack needed for payloads,
+// avoiding a dependency for a fixed schema.
+type msgpackWriter struct {
+	buf []byte
+}
+
+func (w *msgpackWriter) writeNil() {
+	w.buf = append(w.buf, 0xc0)
+}
+
+func (w *msgpackWriter) writeBool(value bool) {
+	if value {
+		w.buf = append(w.buf, 0xc3)
+	} else {
+		w.buf = append(w.buf, 0xc2)
+	}
+}
+
+func (w *msgpackWriter) writeInt(value int64) {
+	switch {
+	case value >= 0 && value <= math.MaxInt8:
+		w.buf = append(w.buf, byte(value)) // Positive fixint
+	case value < 0 && value >= -32:
+		w.buf = append(w.buf, byte(int8(value))) // Negative fixint
+	case value >= math.MinInt16 && value <= math.MaxInt16:
+		w.buf = append(w.buf, 0xd1)
+		w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(value))
+	case value >= math.MinInt32 && value <= math.MaxInt32:
+		w.buf = append(w.buf, 0xd2)
+		w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(value))
+	default:
+		w.buf = append(w.buf, 0xd3)
+		w.buf = binary.BigEndian.AppendUint64(w.buf, uint64(value))
+	}
+}
+
+func (w *msgpackWriter) writeFloat(value float64) {
+	w.buf = append(w.buf, 0xcb)
+	w.buf = binary.BigEndian.AppendUint64(w.buf, math.Float64bits(value))
+}
+
+func (w *msgpackWriter) writeString(value string) {
+	n := len(value)
+	switch {
+	case n < 32:
+		w.buf = append(w.buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		w.buf = append(w.buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		w.buf = append(w.buf, 0xda)
+		w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(n))
+	default:
+		w.buf = append(w.buf, 0xdb)
+		w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(n))
+	}
+	w.buf = append(w.buf, value...)
+}
+
+func (w *msgpackWriter) writeArrayHeader(n int) {
+	switch {
+	case n < 16:
+		w.buf = append(w.buf, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		w.buf = append(w.buf, 0xdc)
+		w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(n))
+	default:
+		w.buf = append(w.buf, 0xdd)
+		w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(n))
+	}
+}
+
+func (w *msgpackWriter) writeMapHeader(n int) {
+	switch {
+	case n < 16:
+		w.buf = append(w.buf, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		w.buf = append(w.buf, 0xde)
+		w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(n))
+	default:
+		w.buf = append(w.buf, 0xdf)
+		w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(n))
+	}
+}
+
+// encodeMsgpack encodes a payload as MessagePack maps keyed by the same
+// field names as its JSON encoding, omitting the same empty fields.
+func encodeMsgpack(payload Payload) []byte {
+	w := &msgpackWriter{}
+	if payload.BatchID != "" {
+		w.writeMapHeader(5)
+		w.writeString("batch_id")
+		w.writeString(payload.BatchID)
+	} else {
+		w.writeMapHeader(4)
+	}
+	w.writeString("api_key")
+	w.writeString(payload.APIKey)
+	w.writeString("requests")
+	if payload.Requests == nil {
+		w.writeNil()
+	} else {
+		w.writeArrayHeader(len(payload.Requests))
+		for _, request := range payload.Requests {
+			w.writeRequest(request)
+		}
+	}
+	w.writeString("framework")
+	w.writeString(payload.Framework)
+	w.writeString("privacy_level")
+	w.writeInt(int64(payload.PrivacyLevel))
+	return w.buf
+}
+
+func (w *msgpackWriter) writeRequest(r RequestData) {
+	n := 14
+	for _, set := range []bool{r.Weight != 0, len(r.Tags) > 0, r.ErrorClass != "", r.ErrorMessage != "", r.Sequence != 0} {
+		if set {
+			n++
+		}
+	}
+	w.writeMapHeader(n)
+
+	w.writeString("hostname")
+	w.writeString(r.Hostname)
+	w.writeString("ip_address")
+	w.writeString(r.IPAddress)
+	w.writeString("path")
+	w.writeString(r.Path)
+	w.writeString("user_agent")
+	w.writeString(r.UserAgent)
+	w.writeString("method")
+	w.writeString(r.Method)
+	w.writeString("response_time")
+	w.writeInt(r.ResponseTime)
+	w.writeString("response_time_us")
+	w.writeInt(r.ResponseTimeMicros)
+	w.writeString("status")
+	w.writeInt(int64(r.Status))
+	w.writeString("user_id")
+	w.writeString(r.UserID)
+	w.writeString("created_at")
+	w.writeString(r.CreatedAt)
+	if r.Weight != 0 {
+		w.writeString("weight")
+		w.writeFloat(r.Weight)
+	}
+	w.writeString("request_size")
+	w.writeInt(r.RequestSize)
+	w.writeString("response_size")
+	w.writeInt(r.ResponseSize)
+	w.writeString("protocol")
+	w.writeString(r.Protocol)
+	w.writeString("tls")
+	w.writeBool(r.TLS)
+	if len(r.Tags) > 0 {
+		w.writeString("tags")
+		w.writeMapHeader(len(r.Tags))
+		for key, value := range r.Tags {
+			w.writeString(key)
+			w.writeString(value)
+		}
+	}
+	if r.ErrorClass != "" {
+		w.writeString("error_class")
+		w.writeString(r.ErrorClass)
+	}
+	if r.ErrorMessage != "" {
+		w.writeString("error_message")
+		w.writeString(r.ErrorMessage)
+	}
+	if r.Sequence != 0 {
+		w.writeString("seq")
+		w.writeInt(int64(r.Sequence))
+	}
+}
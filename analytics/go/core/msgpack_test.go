@@ -0,0 +1,133 @@
+package core
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// decodeMsgpack decodes the subset of MessagePack written by msgpackWriter,
+// with numbers as float64 to compare against decoded JSON.
+func decodeMsgpack(data []byte) (any, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("unexpected end of data")
+	}
+	b, data := data[0], data[1:]
+	switch {
+	case b <= 0x7f:
+		return float64(b), data, nil
+	case b >= 0xe0:
+		return float64(int8(b)), data, nil
+	case b&0xe0 == 0xa0:
+		n := int(b & 0x1f)
+		return string(data[:n]), data[n:], nil
+	case b&0xf0 == 0x90:
+		return decodeMsgpackArray(int(b&0x0f), data)
+	case b&0xf0 == 0x80:
+		return decodeMsgpackMap(int(b&0x0f), data)
+	}
+	switch b {
+	case 0xc0:
+		return nil, data, nil
+	case 0xc2:
+		return false, data, nil
+	case 0xc3:
+		return true, data, nil
+	case 0xd1:
+		return float64(int16(binary.BigEndian.Uint16(data))), data[2:], nil
+	case 0xd2:
+		return float64(int32(binary.BigEndian.Uint32(data))), data[4:], nil
+	case 0xd3:
+		return float64(int64(binary.BigEndian.Uint64(data))), data[8:], nil
+	case 0xcb:
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), data[8:], nil
+	case 0xd9:
+		n := int(data[0])
+		return string(data[1 : 1+n]), data[1+n:], nil
+	case 0xda:
+		n := int(binary.BigEndian.Uint16(data))
+		return string(data[2 : 2+n]), data[2+n:], nil
+	case 0xdc:
+		return decodeMsgpackArray(int(binary.BigEndian.Uint16(data)), data[2:])
+	case 0xde:
+		return decodeMsgpackMap(int(binary.BigEndian.Uint16(data)), data[2:])
+	}
+	return nil, nil, fmt.Errorf("unexpected type %#x", b)
+}
+
+func decodeMsgpackArray(n int, data []byte) (any, []byte, error) {
+	values := make([]any, n)
+	for i := range values {
+		var err error
+		if values[i], data, err = decodeMsgpack(data); err != nil {
+			return nil, nil, err
+		}
+	}
+	return values, data, nil
+}
+
+func decodeMsgpackMap(n int, data []byte) (any, []byte, error) {
+	values := make(map[string]any, n)
+	for i := 0; i < n; i++ {
+		key, rest, err := decodeMsgpack(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		var value any
+		if value, data, err = decodeMsgpack(rest); err != nil {
+			return nil, nil, err
+		}
+		values[key.(string)] = value
+	}
+	return values, data, nil
+}
+
+func TestEncodeMsgpackMatchesJSON(t *testing.T) {
+	payloads := []Payload{
+		{APIKey: "test", Framework: "Gin"},
+		{
+			APIKey:       "test",
+			Framework:    "Gin",
+			PrivacyLevel: 2,
+			BatchID:      "0b5c8a4e-2f6d-4b1a-9c3e-7d8f9a0b1c2d",
+			Requests: []RequestData{
+				{Path: "/", Method: "GET", Status: 200, ResponseTime: 1, ResponseTimeMicros: 1500, CreatedAt: "2024-01-01T00:00:00Z"},
+				{
+					Hostname:           "example.com",
+					Path:               "/api/" + string(make([]byte, 300)),
+					UserAgent:          "Mozilla/5.0",
+					Method:             "POST",
+					Status:             500,
+					ResponseTime:       70000,
+					ResponseTimeMicros: 70_000_000_000,
+					Weight:             2.5,
+					RequestSize:        -1,
+					ResponseSize:       100000,
+					Protocol:           "HTTP/2.0",
+					TLS:                true,
+					Tags:               map[string]string{"region": "eu-west", "version": "2"},
+					ErrorClass:         ErrorClassPanic,
+					ErrorMessage:       "runtime error",
+					Sequence:           1,
+				},
+			},
+		},
+	}
+
+	for i, payload := range payloads {
+		got, rest, err := decodeMsgpack(encodeMsgpack(payload))
+		if err != nil || len(rest) != 0 {
+			t.Fatalf("%d: got error %v with %d bytes remaining", i, err, len(rest))
+		}
+
+		data, _ := json.Marshal(payload)
+		var expected any
+		json.Unmarshal(data, &expected)
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%d: got %v, expected %v", i, got, expected)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package core
+
+import (
+	"regexp"
+	"strings"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// NormalisePath replaces numeric IDs and UUIDs in a path with placeholders,
+// so that requests to the same endpoint are grouped together when no route
+// pattern is available from the router.
+func NormalisePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if isNumeric(segment) {
+			segments[i] = ":id"
+		} else if uuidPattern.MatchString(segment) {
+			segments[i] = ":uuid"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func isNumeric(value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,21 @@
+package core
+
+import "testing"
+
+func TestNormalisePath(t *testing.T) {
+	tests := map[string]string{
+		"/":                    "/",
+		"/users":               "/users",
+		"/users/123":           "/users/:id",
+		"/users/123/posts/456": "/users/:id/posts/:id",
+		"/orders/2b1f6e3a-9c0d-4f8e-a1b2-3c4d5e6f7a8b": "/orders/:uuid",
+		"/v2/items":              "/v2/items",
+		"/files/report-2024.pdf": "/files/report-2024.pdf",
+	}
+
+	for path, expected := range tests {
+		if got := NormalisePath(path); got != expected {
+			t.Errorf("%s: got %s, expected %s", path, got, expected)
+		}
+	}
+}
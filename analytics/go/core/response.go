@@ -0,0 +1,93 @@
+package core
+
+import (
+	"io"
+	"net/http"
+)
+
+// ResponseWriter wraps an http.ResponseWriter to record the status code and
+// number of body bytes written by the handler, for middlewares built on
+// net/http.
+type ResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	size        int64
+}
+
+func NewResponseWriter(w http.ResponseWriter) *ResponseWriter {
+	return &ResponseWriter{ResponseWriter: w}
+}
+
+func (rw *ResponseWriter) WriteHeader(code int) {
+	if rw.wroteHeader {
+		return
+	}
+
+	rw.status = code
+	rw.ResponseWriter.WriteHeader(code)
+	rw.wroteHeader = true
+}
+
+func (rw *ResponseWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		// Status implicitly set by the first write
+		rw.WriteHeader(http.StatusOK)
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.size += int64(n)
+	return n, err
+}
+
+// Status returns the status code written, or 200 if the handler returned
+// without writing a response.
+func (rw *ResponseWriter) Status() int {
+	if !rw.wroteHeader {
+		return http.StatusOK
+	}
+	return rw.status
+}
+
+// Written reports whether the response header has been written.
+func (rw *ResponseWriter) Written() bool {
+	return rw.wroteHeader
+}
+
+// Size returns the number of response body bytes written.
+func (rw *ResponseWriter) Size() int64 {
+	return rw.size
+}
+
+// Unwrap allows http.ResponseController to access the underlying writer.
+func (rw *ResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// BodyCounter wraps a request body to count the bytes read by the handler,
+// for requests sent without a Content-Length.
+type BodyCounter struct {
+	io.ReadCloser
+	size int64
+}
+
+func NewBodyCounter(body io.ReadCloser) *BodyCounter {
+	return &BodyCounter{ReadCloser: body}
+}
+
+func (b *BodyCounter) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.size += int64(n)
+	return n, err
+}
+
+// RequestSize returns the request body size, taken from the Content-Length
+// header if present or otherwise the number of bytes read.
+func RequestSize(r *http.Request, body *BodyCounter) int64 {
+	if r.ContentLength > 0 {
+		return r.ContentLength
+	}
+	if body == nil {
+		return 0
+	}
+	return body.size
+}
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const maxRetryBackoff time.Duration = 2 * time.Minute
+
+// StatusError is returned when the server responds to an upload with a
+// non-success status code.
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration // Zero if the server did not send Retry-After
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("api analytics server responded with status %d", e.StatusCode)
+}
+
+// permanent reports whether an upload error will not be fixed by sending the
+// same batch again, such as a 400 for invalid request data.
+func permanent(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode != http.StatusTooManyRequests && statusErr.StatusCode < 500
+	}
+	return false
+}
+
+func (e *HTTPExporter) sendWithRetry(ctx context.Context, body []byte, format payloadFormat) error {
+	backoff := e.retryBackoff
+	for attempt := 0; ; attempt++ {
+		err := e.send(ctx, body, format)
+		if err == nil || permanent(err) || attempt >= e.maxRetries {
+			return err
+		}
+
+		delay := backoff + time.Duration(rand.Int63n(int64(backoff)/2+1)) // Add jitter
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+			delay = statusErr.RetryAfter
+		}
+		if delay > maxRetryBackoff {
+			delay = maxRetryBackoff
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an
+// HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
@@ -0,0 +1,130 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// spool stores encoded batches that failed to upload as individual files in a
+// local directory, oldest first, so they can be sent once the server is
+// reachable again.
+type spool struct {
+	dir      string
+	maxBytes int64
+
+	mu        sync.Mutex
+	replaying sync.Mutex
+}
+
+func newSpool(dir string, maxBytes int64) *spool {
+	return &spool{dir: dir, maxBytes: maxBytes}
+}
+
+type spoolFile struct {
+	name string
+	size int64
+}
+
+// files returns the spooled batches ordered from oldest to newest.
+func (s *spool) files() ([]spoolFile, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	files := make([]spoolFile, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, spoolFile{name: entry.Name(), size: info.Size()})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].name < files[j].name
+	})
+	return files, nil
+}
+
+// write adds a batch to the spool, discarding the oldest batches if the spool
+// would otherwise grow beyond its size limit.
+func (s *spool) write(body []byte) error {
+	size := int64(len(body))
+	if size > s.maxBytes {
+		return fmt.Errorf("batch of %d bytes exceeds spool limit", size)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	for len(files) > 0 && total+size > s.maxBytes {
+		os.Remove(filepath.Join(s.dir, files[0].name))
+		total -= files[0].size
+		files = files[1:]
+	}
+
+	// Write to a temporary file first so a partially written batch is never replayed
+	name := fmt.Sprintf("%020d.json", time.Now().UnixNano())
+	tmp := filepath.Join(s.dir, name+".tmp")
+	if err := os.WriteFile(tmp, body, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, name))
+}
+
+// replay sends spooled batches in order, removing each one once it has been
+// accepted or permanently rejected. It stops at the first batch that fails
+// for a reason that may be temporary.
+func (s *spool) replay(ctx context.Context, send func(ctx context.Context, body []byte) error) {
+	if !s.replaying.TryLock() {
+		return // Already being replayed
+	}
+	defer s.replaying.Unlock()
+
+	files, err := s.files()
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		path := filepath.Join(s.dir, f.name)
+		body, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if err := send(ctx, body); err != nil && !permanent(err) {
+			return
+		}
+		os.Remove(path)
+	}
+}
+
+func (e *HTTPExporter) replaySpool(ctx context.Context) {
+	if e.spool != nil {
+		e.spool.replay(ctx, func(ctx context.Context, body []byte) error {
+			return e.send(ctx, body, jsonFormat)
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package analytics
 
 import (
+	"errors"
+	"net/http"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -8,53 +10,125 @@ import (
 )
 
 type Config struct {
-	PrivacyLevel int
-	ServerURL    string
-	GetPath      func(c echo.Context) string
-	GetHostname  func(c echo.Context) string
-	GetUserAgent func(c echo.Context) string
-	GetIPAddress func(c echo.Context) string
-	GetUserID    func(c echo.Context) string
+	PrivacyLevel         int
+	ServerURL            string
+	Filter               core.Filter // Rules deciding which requests are logged
+	CaptureErrorMessages bool        // Send truncated error and panic messages to the server
+	GetPath              func(c echo.Context) string
+	GetHostname          func(c echo.Context) string
+	GetUserAgent         func(c echo.Context) string
+	GetIPAddress         func(c echo.Context) string
+	GetUserID            func(c echo.Context) string
+	GetTags              func(c echo.Context) map[string]string // Optional low-cardinality key/value tags
 }
 
 func NewConfig() *Config {
 	return &Config{
 		PrivacyLevel: 0,
-		ServerURL: core.DefaultServerURL,
-		GetPath: GetPath,
-		GetHostname: GetHostname,
+		ServerURL:    core.DefaultServerURL,
+		GetPath:      GetPath,
+		GetHostname:  GetHostname,
 		GetUserAgent: GetUserAgent,
 		GetIPAddress: GetIPAddress,
-		GetUserID: GetUserID
+		GetUserID:    GetUserID,
 	}
 }
 
+// Framework is the name reported to the server for requests logged by this
+// middleware.
+const Framework string = "Echo"
+
+// NewClient creates a client to batch and post the requests logged by the
+// middleware. Close the client on shutdown to send any pending requests.
+func NewClient(apiKey string, config *Config) *core.Client {
+	clientConfig := core.NewConfig()
+	clientConfig.PrivacyLevel = config.PrivacyLevel
+	if config.ServerURL != "" {
+		clientConfig.ServerURL = config.ServerURL
+	}
+	return core.NewClient(apiKey, Framework, clientConfig)
+}
+
 func Analytics(apiKey string) echo.MiddlewareFunc {
 	return AnalyticsWithConfig(apiKey, &Config{})
 }
 
 func AnalyticsWithConfig(apiKey string, config *Config) echo.MiddlewareFunc {
+	return AnalyticsWithClient(NewClient(apiKey, config), config)
+}
+
+func AnalyticsWithClient(client *core.Client, config *Config) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
+			var body *core.BodyCounter
+			if r := c.Request(); r.Body != nil && r.Body != http.NoBody {
+				body = core.NewBodyCounter(r.Body)
+				r.Body = body
+			}
+
 			start := time.Now()
+			defer func() {
+				if rec := recover(); rec != nil {
+					// Record the panic before passing it on to the recover middleware
+					logRequest(client, config, c, body, start, nil, rec)
+					panic(rec)
+				}
+			}()
 			err := next(c)
+			logRequest(client, config, c, body, start, err, nil)
+			return err
+		}
+	}
+}
 
-			data := core.RequestData{
-				Hostname:     getHostname(c, config),
-				IPAddress:    getIPAddress(c, config),
-				Path:         getPath(c, config),
-				UserAgent:    getUserAgent(c, config),
-				Method:       c.Request().Method,
-				Status:       c.Response().Status,
-				ResponseTime: time.Since(start).Milliseconds(),
-				UserID:       getUserID(c, config),
-				CreatedAt:    start.Format(time.RFC3339),
-			}
+func logRequest(client *core.Client, config *Config, c echo.Context, body *core.BodyCounter, start time.Time, err error, rec any) {
+	elapsed := time.Since(start)
+	data := core.RequestData{
+		Hostname:           getHostname(c, config),
+		IPAddress:          getIPAddress(c, config),
+		Path:               getPath(c, config),
+		UserAgent:          getUserAgent(c, config),
+		Method:             c.Request().Method,
+		Status:             c.Response().Status,
+		ResponseTime:       elapsed.Milliseconds(),
+		ResponseTimeMicros: elapsed.Microseconds(),
+		UserID:             getUserID(c, config),
+		CreatedAt:          start.Format(time.RFC3339),
+		RequestSize:        core.RequestSize(c.Request(), body),
+		ResponseSize:       c.Response().Size,
+		Protocol:           c.Request().Proto,
+		TLS:                c.Request().TLS != nil,
+		Tags:               getTags(c, config),
+	}
 
-			core.LogRequest(apiKey, data, "Echo", config.PrivacyLevel, config.ServerURL)
-			return err
+	if rec != nil {
+		if !c.Response().Committed {
+			data.Status = http.StatusInternalServerError
 		}
+		data.SetError(core.ErrorClassPanic, rec, config.CaptureErrorMessages)
+	} else if class := core.ClassifyError(c.Request().Context(), err); class != "" {
+		if err != nil && !c.Response().Committed {
+			// Response is written by the error handler after the middleware returns
+			data.Status = getErrorStatus(err)
+		}
+		data.SetError(class, err, config.CaptureErrorMessages)
+	}
+
+	if !config.Filter.Apply(&data) {
+		return
+	}
+
+	client.LogRequest(data)
+}
+
+// getErrorStatus returns the status code the default error handler responds
+// with for an error returned by a handler.
+func getErrorStatus(err error) int {
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Code
 	}
+	return http.StatusInternalServerError
 }
 
 func getHostname(c echo.Context, config *Config) string {
@@ -90,6 +164,13 @@ func getIPAddress(c echo.Context, config *Config) string {
 	return GetIPAddress(c)
 }
 
+func getTags(c echo.Context, config *Config) map[string]string {
+	if config.GetTags != nil {
+		return config.GetTags(c)
+	}
+	return nil
+}
+
 func getUserID(c echo.Context, config *Config) string {
 	if config.GetUserID != nil {
 		return config.GetUserID(c)
@@ -105,6 +186,16 @@ func GetPath(c echo.Context) string {
 	return c.Request().URL.Path
 }
 
+// GetRoutePattern returns the route pattern matched by the router, such as
+// /users/:id, falling back to the path with IDs replaced by placeholders if
+// no route matched. Assign it to Config.GetPath to group requests by endpoint.
+func GetRoutePattern(c echo.Context) string {
+	if pattern := c.Path(); pattern != "" {
+		return pattern
+	}
+	return core.NormalisePath(c.Request().URL.Path)
+}
+
 func GetUserAgent(c echo.Context) string {
 	return c.Request().UserAgent()
 }
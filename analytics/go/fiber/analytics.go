@@ -1,6 +1,7 @@
 package analytics
 
 import (
+	"errors"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -8,52 +9,115 @@ import (
 )
 
 type Config struct {
-	PrivacyLevel int
-	ServerURL    string
-	GetPath      func(c *fiber.Ctx) string
-	GetHostname  func(c *fiber.Ctx) string
-	GetUserAgent func(c *fiber.Ctx) string
-	GetIPAddress func(c *fiber.Ctx) string
-	GetUserID    func(c *fiber.Ctx) string
+	PrivacyLevel         int
+	ServerURL            string
+	Filter               core.Filter // Rules deciding which requests are logged
+	CaptureErrorMessages bool        // Send truncated error and panic messages to the server
+	GetPath              func(c *fiber.Ctx) string
+	GetHostname          func(c *fiber.Ctx) string
+	GetUserAgent         func(c *fiber.Ctx) string
+	GetIPAddress         func(c *fiber.Ctx) string
+	GetUserID            func(c *fiber.Ctx) string
+	GetTags              func(c *fiber.Ctx) map[string]string // Optional low-cardinality key/value tags
 }
 
 func NewConfig() *Config {
 	return &Config{
 		PrivacyLevel: 0,
-		ServerURL: core.DefaultServerURL,
-		GetPath: GetPath,
-		GetHostname: GetHostname,
+		ServerURL:    core.DefaultServerURL,
+		GetPath:      GetPath,
+		GetHostname:  GetHostname,
 		GetUserAgent: GetUserAgent,
 		GetIPAddress: GetIPAddress,
-		GetUserID: GetUserID
+		GetUserID:    GetUserID,
 	}
 }
 
+// Framework is the name reported to the server for requests logged by this
+// middleware.
+const Framework string = "Fiber"
+
+// NewClient creates a client to batch and post the requests logged by the
+// middleware. Close the client on shutdown to send any pending requests.
+func NewClient(apiKey string, config *Config) *core.Client {
+	clientConfig := core.NewConfig()
+	clientConfig.PrivacyLevel = config.PrivacyLevel
+	if config.ServerURL != "" {
+		clientConfig.ServerURL = config.ServerURL
+	}
+	return core.NewClient(apiKey, Framework, clientConfig)
+}
+
 func Analytics(apiKey string) func(c *fiber.Ctx) error {
 	return AnalyticsWithConfig(apiKey, &Config{})
 }
 
 func AnalyticsWithConfig(apiKey string, config *Config) func(c *fiber.Ctx) error {
+	return AnalyticsWithClient(NewClient(apiKey, config), config)
+}
+
+func AnalyticsWithClient(client *core.Client, config *Config) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		start := time.Now()
+		defer func() {
+			if rec := recover(); rec != nil {
+				// Record the panic before passing it on to the recover middleware
+				logRequest(client, config, c, start, nil, rec)
+				panic(rec)
+			}
+		}()
 		err := c.Next()
+		logRequest(client, config, c, start, err, nil)
+		return err
+	}
+}
 
-		data := core.RequestData{
-			Hostname:     getHostname(c, config),
-			Path:         getPath(c, config),
-			IPAddress:    getIPAddress(c, config),
-			UserAgent:    getUserAgent(c, config),
-			Method:       c.Method(),
-			Status:       c.Response().StatusCode(),
-			ResponseTime: time.Since(start).Milliseconds(),
-			UserID:       getUserID(c, config),
-			CreatedAt:    start.Format(time.RFC3339),
+func logRequest(client *core.Client, config *Config, c *fiber.Ctx, start time.Time, err error, rec any) {
+	elapsed := time.Since(start)
+	data := core.RequestData{
+		Hostname:           getHostname(c, config),
+		Path:               getPath(c, config),
+		IPAddress:          getIPAddress(c, config),
+		UserAgent:          getUserAgent(c, config),
+		Method:             c.Method(),
+		Status:             c.Response().StatusCode(),
+		ResponseTime:       elapsed.Milliseconds(),
+		ResponseTimeMicros: elapsed.Microseconds(),
+		UserID:             getUserID(c, config),
+		CreatedAt:          start.Format(time.RFC3339),
+		RequestSize:        int64(len(c.Request().Body())),
+		ResponseSize:       int64(len(c.Response().Body())),
+		Protocol:           string(c.Request().Header.Protocol()),
+		TLS:                c.Context().IsTLS(),
+		Tags:               getTags(c, config),
+	}
+
+	if rec != nil {
+		data.Status = fiber.StatusInternalServerError
+		data.SetError(core.ErrorClassPanic, rec, config.CaptureErrorMessages)
+	} else if class := core.ClassifyError(c.Context(), err); class != "" {
+		if err != nil {
+			// Response is written by the error handler after the middleware returns
+			data.Status = getErrorStatus(err)
 		}
+		data.SetError(class, err, config.CaptureErrorMessages)
+	}
 
-		core.LogRequest(apiKey, data, "Fiber", config.PrivacyLevel, config.ServerURL)
+	if !config.Filter.Apply(&data) {
+		return
+	}
 
-		return err
+	client.LogRequest(data)
+}
+
+// getErrorStatus returns the status code the default error handler responds
+// with for an error returned by a handler.
+func getErrorStatus(err error) int {
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return fiberErr.Code
 	}
+	return fiber.StatusInternalServerError
 }
 
 func getHostname(c *fiber.Ctx, config *Config) string {
@@ -88,6 +152,13 @@ func getIPAddress(c *fiber.Ctx, config *Config) string {
 	return GetIPAddress(c)
 }
 
+func getTags(c *fiber.Ctx, config *Config) map[string]string {
+	if config.GetTags != nil {
+		return config.GetTags(c)
+	}
+	return nil
+}
+
 func getUserID(c *fiber.Ctx, config *Config) string {
 	if config.GetUserID != nil {
 		return config.GetUserID(c)
@@ -103,6 +174,17 @@ func GetPath(c *fiber.Ctx) string {
 	return c.Path()
 }
 
+// GetRoutePattern returns the route pattern matched by the router, such as
+// /users/:id, falling back to the path with IDs replaced by placeholders if
+// no route matched. Assign it to Config.GetPath to group requests by endpoint.
+func GetRoutePattern(c *fiber.Ctx) string {
+	// Middleware added with Use matches "/" when no other route handled the request
+	if route := c.Route(); route.Path != "" && (route.Path != "/" || c.Path() == "/") {
+		return route.Path
+	}
+	return core.NormalisePath(c.Path())
+}
+
 func GetUserAgent(c *fiber.Ctx) string {
 	return string(c.Request().Header.UserAgent())
 }
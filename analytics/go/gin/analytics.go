@@ -1,6 +1,7 @@
 package analytics
 
 import (
+	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -8,50 +9,115 @@ import (
 )
 
 type Config struct {
-	PrivacyLevel int
-	ServerURL    string
-	GetPath      func(c *gin.Context) string
-	GetHostname  func(c *gin.Context) string
-	GetUserAgent func(c *gin.Context) string
-	GetIPAddress func(c *gin.Context) string
-	GetUserID    func(c *gin.Context) string
+	PrivacyLevel         int
+	ServerURL            string
+	Filter               core.Filter // Rules deciding which requests are logged
+	CaptureErrorMessages bool        // Send truncated error and panic messages to the server
+	GetPath              func(c *gin.Context) string
+	GetHostname          func(c *gin.Context) string
+	GetUserAgent         func(c *gin.Context) string
+	GetIPAddress         func(c *gin.Context) string
+	GetUserID            func(c *gin.Context) string
+	GetTags              func(c *gin.Context) map[string]string // Optional low-cardinality key/value tags
 }
 
 func NewConfig() *Config {
 	return &Config{
 		PrivacyLevel: 0,
-		ServerURL: core.DefaultServerURL,
-		GetPath: GetPath,
-		GetHostname: GetHostname,
+		ServerURL:    core.DefaultServerURL,
+		GetPath:      GetPath,
+		GetHostname:  GetHostname,
 		GetUserAgent: GetUserAgent,
 		GetIPAddress: GetIPAddress,
-		GetUserID: GetUserID
+		GetUserID:    GetUserID,
 	}
 }
 
+// Framework is the name reported to the server for requests logged by this
+// middleware.
+const Framework string = "Gin"
+
+// NewClient creates a client to batch and post the requests logged by the
+// middleware. Close the client on shutdown to send any pending requests.
+func NewClient(apiKey string, config *Config) *core.Client {
+	clientConfig := core.NewConfig()
+	clientConfig.PrivacyLevel = config.PrivacyLevel
+	if config.ServerURL != "" {
+		clientConfig.ServerURL = config.ServerURL
+	}
+	return core.NewClient(apiKey, Framework, clientConfig)
+}
+
 func Analytics(apiKey string) gin.HandlerFunc {
 	return AnalyticsWithConfig(apiKey, &Config{})
 }
 
 func AnalyticsWithConfig(apiKey string, config *Config) gin.HandlerFunc {
+	return AnalyticsWithClient(NewClient(apiKey, config), config)
+}
+
+func AnalyticsWithClient(client *core.Client, config *Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		var body *core.BodyCounter
+		if c.Request.Body != nil && c.Request.Body != http.NoBody {
+			body = core.NewBodyCounter(c.Request.Body)
+			c.Request.Body = body
+		}
+
 		start := time.Now()
+		defer func() {
+			if rec := recover(); rec != nil {
+				// Record the panic before passing it on to the recovery middleware
+				logRequest(client, config, c, body, start, rec)
+				panic(rec)
+			}
+		}()
 		c.Next()
+		logRequest(client, config, c, body, start, nil)
+	}
+}
+
+func logRequest(client *core.Client, config *Config, c *gin.Context, body *core.BodyCounter, start time.Time, rec any) {
+	elapsed := time.Since(start)
+	data := core.RequestData{
+		Hostname:           getHostname(c, config),
+		IPAddress:          getIPAddress(c, config),
+		Path:               getPath(c, config),
+		UserAgent:          getUserAgent(c, config),
+		Method:             c.Request.Method,
+		Status:             c.Writer.Status(),
+		ResponseTime:       elapsed.Milliseconds(),
+		ResponseTimeMicros: elapsed.Microseconds(),
+		UserID:             getUserID(c, config),
+		CreatedAt:          start.Format(time.RFC3339),
+		RequestSize:        core.RequestSize(c.Request, body),
+		ResponseSize:       getResponseSize(c),
+		Protocol:           c.Request.Proto,
+		TLS:                c.Request.TLS != nil,
+		Tags:               getTags(c, config),
+	}
 
-		data := core.RequestData{
-			Hostname:     getHostname(c, config),
-			IPAddress:    getIPAddress(c, config),
-			Path:         getPath(c, config),
-			UserAgent:    getUserAgent(c, config),
-			Method:       c.Request.Method,
-			Status:       c.Writer.Status(),
-			ResponseTime: time.Since(start).Milliseconds(),
-			UserID:       getUserID(c, config),
-			CreatedAt:    start.Format(time.RFC3339),
+	if rec != nil {
+		if !c.Writer.Written() {
+			data.Status = http.StatusInternalServerError
+		}
+		data.SetError(core.ErrorClassPanic, rec, config.CaptureErrorMessages)
+	} else {
+		// Errors attached to the context by handlers with c.Error
+		var err error
+		if last := c.Errors.Last(); last != nil {
+			err = last.Err
+		}
+		if class := core.ClassifyError(c.Request.Context(), err); class != "" {
+			data.SetError(class, err, config.CaptureErrorMessages)
 		}
+	}
 
-		core.LogRequest(apiKey, data, "Gin", config.PrivacyLevel, config.ServerURL)
+	if !config.Filter.Apply(&data) {
+		return
 	}
+
+	client.LogRequest(data)
 }
 
 func getHostname(c *gin.Context, config *Config) string {
@@ -87,6 +153,13 @@ func getIPAddress(c *gin.Context, config *Config) string {
 	return GetIPAddress(c)
 }
 
+func getTags(c *gin.Context, config *Config) map[string]string {
+	if config.GetTags != nil {
+		return config.GetTags(c)
+	}
+	return nil
+}
+
 func getUserID(c *gin.Context, config *Config) string {
 	if config.GetUserID != nil {
 		return config.GetUserID(c)
@@ -94,6 +167,14 @@ func getUserID(c *gin.Context, config *Config) string {
 	return GetUserID(c)
 }
 
+func getResponseSize(c *gin.Context) int64 {
+	// Size is -1 if nothing has been written
+	if size := c.Writer.Size(); size > 0 {
+		return int64(size)
+	}
+	return 0
+}
+
 func GetHostname(c *gin.Context) string {
 	return c.Request.Host
 }
@@ -102,6 +183,16 @@ func GetPath(c *gin.Context) string {
 	return c.Request.URL.Path
 }
 
+// GetRoutePattern returns the route pattern matched by the router, such as
+// /users/:id, falling back to the path with IDs replaced by placeholders if
+// no route matched. Assign it to Config.GetPath to group requests by endpoint.
+func GetRoutePattern(c *gin.Context) string {
+	if pattern := c.FullPath(); pattern != "" {
+		return pattern
+	}
+	return core.NormalisePath(c.Request.URL.Path)
+}
+
 func GetUserAgent(c *gin.Context) string {
 	return c.Request.UserAgent()
 }
@@ -112,4 +203,4 @@ func GetIPAddress(c *gin.Context) string {
 
 func GetUserID(c *gin.Context) string {
 	return ""
-}
\ No newline at end of file
+}
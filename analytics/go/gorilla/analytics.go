@@ -0,0 +1,200 @@
+package analytics
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/tom-draper/api-analytics/analytics/go/core"
+)
+
+type Config struct {
+	PrivacyLevel         int
+	ServerURL            string
+	Filter               core.Filter // Rules deciding which requests are logged
+	CaptureErrorMessages bool        // Send truncated error and panic messages to the server
+	GetPath              func(r *http.Request) string
+	GetHostname          func(r *http.Request) string
+	GetUserAgent         func(r *http.Request) string
+	GetIPAddress         func(r *http.Request) string
+	GetUserID            func(r *http.Request) string
+	GetTags              func(r *http.Request) map[string]string // Optional low-cardinality key/value tags
+}
+
+func NewConfig() *Config {
+	return &Config{
+		PrivacyLevel: 0,
+		ServerURL:    core.DefaultServerURL,
+		GetPath:      GetPath,
+		GetHostname:  GetHostname,
+		GetUserAgent: GetUserAgent,
+		GetIPAddress: GetIPAddress,
+		GetUserID:    GetUserID,
+	}
+}
+
+// Framework is the name reported to the server for requests logged by this
+// middleware.
+const Framework string = "Gorilla"
+
+// NewClient creates a client to batch and post the requests logged by the
+// middleware. Close the client on shutdown to send any pending requests.
+func NewClient(apiKey string, config *Config) *core.Client {
+	clientConfig := core.NewConfig()
+	clientConfig.PrivacyLevel = config.PrivacyLevel
+	if config.ServerURL != "" {
+		clientConfig.ServerURL = config.ServerURL
+	}
+	return core.NewClient(apiKey, Framework, clientConfig)
+}
+
+func Analytics(apiKey string) mux.MiddlewareFunc {
+	return AnalyticsWithConfig(apiKey, &Config{})
+}
+
+func AnalyticsWithConfig(apiKey string, config *Config) mux.MiddlewareFunc {
+	return AnalyticsWithClient(NewClient(apiKey, config), config)
+}
+
+func AnalyticsWithClient(client *core.Client, config *Config) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw := core.NewResponseWriter(w) // Wrap to store status code and size
+			var body *core.BodyCounter
+			if r.Body != nil && r.Body != http.NoBody {
+				body = core.NewBodyCounter(r.Body)
+				r.Body = body
+			}
+
+			start := time.Now()
+			defer func() {
+				if rec := recover(); rec != nil {
+					// Record the panic before passing it on to the server
+					logRequest(client, config, rw, r, body, start, rec)
+					panic(rec)
+				}
+			}()
+			next.ServeHTTP(rw, r)
+			logRequest(client, config, rw, r, body, start, nil)
+		})
+	}
+}
+
+func logRequest(client *core.Client, config *Config, rw *core.ResponseWriter, r *http.Request, body *core.BodyCounter, start time.Time, rec any) {
+	elapsed := time.Since(start)
+	data := core.RequestData{
+		Hostname:           getHostname(r, config),
+		IPAddress:          getIPAddress(r, config),
+		Path:               getPath(r, config),
+		UserAgent:          getUserAgent(r, config),
+		Method:             r.Method,
+		Status:             rw.Status(),
+		ResponseTime:       elapsed.Milliseconds(),
+		ResponseTimeMicros: elapsed.Microseconds(),
+		UserID:             getUserID(r, config),
+		CreatedAt:          start.Format(time.RFC3339),
+		RequestSize:        core.RequestSize(r, body),
+		ResponseSize:       rw.Size(),
+		Protocol:           r.Proto,
+		TLS:                r.TLS != nil,
+		Tags:               getTags(r, config),
+	}
+
+	if rec != nil {
+		if !rw.Written() {
+			data.Status = http.StatusInternalServerError
+		}
+		data.SetError(core.ErrorClassPanic, rec, config.CaptureErrorMessages)
+	} else if class := core.ClassifyError(r.Context(), nil); class != "" {
+		data.SetError(class, nil, false)
+	}
+
+	if !config.Filter.Apply(&data) {
+		return
+	}
+
+	client.LogRequest(data)
+}
+
+func getHostname(r *http.Request, config *Config) string {
+	if config.GetHostname != nil {
+		return config.GetHostname(r)
+	}
+	return GetHostname(r)
+}
+
+func getPath(r *http.Request, config *Config) string {
+	if config.GetPath != nil {
+		return config.GetPath(r)
+	}
+	return GetPath(r)
+}
+
+func getUserAgent(r *http.Request, config *Config) string {
+	if config.GetUserAgent != nil {
+		return config.GetUserAgent(r)
+	}
+	return GetUserAgent(r)
+}
+
+func getIPAddress(r *http.Request, config *Config) string {
+	// IP address never sent to the server for privacy level 2 and above
+	if config.PrivacyLevel >= 2 {
+		return ""
+	}
+
+	if config.GetIPAddress != nil {
+		return config.GetIPAddress(r)
+	}
+	return GetIPAddress(r)
+}
+
+func getTags(r *http.Request, config *Config) map[string]string {
+	if config.GetTags != nil {
+		return config.GetTags(r)
+	}
+	return nil
+}
+
+func getUserID(r *http.Request, config *Config) string {
+	if config.GetUserID != nil {
+		return config.GetUserID(r)
+	}
+	return GetUserID(r)
+}
+
+func GetHostname(r *http.Request) string {
+	return r.Host
+}
+
+func GetPath(r *http.Request) string {
+	return r.URL.Path
+}
+
+// GetRoutePattern returns the path template of the route matched by the
+// router, such as /users/{id}, falling back to the path with IDs replaced by
+// placeholders if no route matched. The middleware must be added with
+// Router.Use for the route to be available. Assign it to Config.GetPath to
+// group requests by endpoint.
+func GetRoutePattern(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return core.NormalisePath(r.URL.Path)
+}
+
+func GetUserAgent(r *http.Request) string {
+	return r.UserAgent()
+}
+
+func GetIPAddress(r *http.Request) string {
+	ip, _, _ := net.SplitHostPort(r.RemoteAddr)
+	return ip
+}
+
+func GetUserID(r *http.Request) string {
+	return ""
+}
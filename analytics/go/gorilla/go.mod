@@ -0,0 +1,8 @@
+module github.com/tom-draper/api-analytics/analytics/go/gorilla
+
+go 1.19
+
+require (
+	github.com/gorilla/mux v1.8.1
+	github.com/tom-draper/api-analytics/analytics/go/core v0.0.0-20240603174719-d5fc13e14fb1
+)
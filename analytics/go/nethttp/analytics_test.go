@@ -0,0 +1,119 @@
+package analytics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tom-draper/api-analytics/analytics/go/core"
+)
+
+func TestRoutePattern(t *testing.T) {
+	exporter := core.NewMemoryExporter()
+	clientConfig := core.NewConfig()
+	clientConfig.Exporter = exporter
+	client := core.NewClient("", Framework, clientConfig)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	config := NewConfig()
+	config.GetPath = GetRoutePattern
+	handler := AnalyticsWithClient(client, config)(mux)
+
+	for _, path := range []string{"/users/123", "/orders/456"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	if err := client.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := exporter.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, expected 2", len(requests))
+	}
+	if requests[0].Path != "/users/{id}" || requests[0].Status != http.StatusOK {
+		t.Errorf("got %s %d, expected /users/{id} 200", requests[0].Path, requests[0].Status)
+	}
+	if requests[1].Path != "/orders/:id" || requests[1].Status != http.StatusNotFound {
+		t.Errorf("got %s %d, expected /orders/:id 404", requests[1].Path, requests[1].Status)
+	}
+}
+
+func TestRequestSizes(t *testing.T) {
+	exporter := core.NewMemoryExporter()
+	clientConfig := core.NewConfig()
+	clientConfig.Exporter = exporter
+	client := core.NewClient("", Framework, clientConfig)
+
+	handler := AnalyticsWithClient(client, NewConfig())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("hello"))
+	}))
+
+	// Content-Length known
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader("abc")))
+	// Chunked request body without a Content-Length
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("abcdef"))
+	r.ContentLength = -1
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if err := client.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := exporter.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, expected 2", len(requests))
+	}
+	tests := []struct {
+		requestSize int64
+	}{{3}, {6}}
+	for i, test := range tests {
+		got := requests[i]
+		if got.RequestSize != test.requestSize || got.ResponseSize != 5 {
+			t.Errorf("%d: got %d/%d bytes, expected %d/5", i, got.RequestSize, got.ResponseSize, test.requestSize)
+		}
+		if got.Protocol != "HTTP/1.1" || got.TLS {
+			t.Errorf("%d: got %s tls=%t, expected HTTP/1.1 tls=false", i, got.Protocol, got.TLS)
+		}
+	}
+}
+
+func TestPanic(t *testing.T) {
+	exporter := core.NewMemoryExporter()
+	clientConfig := core.NewConfig()
+	clientConfig.Exporter = exporter
+	client := core.NewClient("", Framework, clientConfig)
+
+	config := NewConfig()
+	config.CaptureErrorMessages = true
+	handler := AnalyticsWithClient(client, config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	func() {
+		defer func() {
+			if rec := recover(); rec != "boom" {
+				t.Errorf("got %v, expected panic to be passed on", rec)
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+	if err := client.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := exporter.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, expected 1", len(requests))
+	}
+	got := requests[0]
+	if got.Status != http.StatusInternalServerError || got.ErrorClass != core.ErrorClassPanic || got.ErrorMessage != "boom" {
+		t.Errorf("got %d %s %q, expected 500 panic \"boom\"", got.Status, got.ErrorClass, got.ErrorMessage)
+	}
+}
@@ -0,0 +1,5 @@
+module github.com/tom-draper/api-analytics/analytics/go/nethttp
+
+go 1.23
+
+require github.com/tom-draper/api-analytics/analytics/go/core v0.0.0-20240603174719-d5fc13e14fb1
@@ -5,7 +5,7 @@ type DashboardData = {
 	requests: RequestsData;
 };
 
-// ip_address, path, hostname, user_agent, method, response_time, status, location, created_at
+// ip_address, path, hostname, user_agent, method, response_time, status, location, created_at, error_class
 type RequestsData = [
 	string,
 	string,
@@ -17,6 +17,7 @@ type RequestsData = [
 	string,
 	string,
 	Date,
+	(number | null)?,
 ][];
 
 type UserAgents = {
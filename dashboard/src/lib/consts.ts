@@ -11,6 +11,7 @@ export const columns = [
 	'location',
 	'user_id',
 	'time',
+	'error_class',
 ];
 
 export const enum ColumnIndex {
@@ -24,6 +25,7 @@ export const enum ColumnIndex {
 	Location = 7,
 	UserID = 8,
 	CreatedAt = 9,
+	ErrorClass = 10,
 }
 
 export const graphColors = [
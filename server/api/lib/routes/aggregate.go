@@ -0,0 +1,283 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/tom-draper/api-analytics/server/api/lib/log"
+	"github.com/tom-draper/api-analytics/server/database"
+)
+
+// aggregateInterval is a bucket width for aggregated series.
+type aggregateInterval struct {
+	name        string
+	step        time.Duration
+	defaultSpan time.Duration // Range covered if not given in the request
+}
+
+var aggregateIntervals = map[string]aggregateInterval{
+	"minute": {"minute", time.Minute, time.Hour},
+	"hour":   {"hour", time.Hour, 24 * time.Hour},
+	"day":    {"day", 24 * time.Hour, 30 * 24 * time.Hour},
+}
+
+// Maximum number of buckets returned in a series, a day of minutes
+const maxAggregateBuckets int = 1440
+
+const (
+	defaultTopLimit int = 10
+	maxTopLimit     int = 100
+)
+
+// topDimension describes the column requests are grouped by to find the most
+// frequent values.
+type topDimension struct {
+	method string // Column grouped by alongside the value, or NULL
+	value  string
+	where  string // Extra condition on the requests counted
+	lookup bool   // Value is a user agent ID to be resolved to its name
+}
+
+var topDimensions = map[string]topDimension{
+	"endpoints":   {method: "r.method", value: "r.path"},
+	"user_agents": {method: "NULL::smallint", value: "r.user_agent_id", lookup: true},
+	"countries":   {method: "NULL::smallint", value: "r.location", where: " and r.location IS NOT NULL"},
+	"users":       {method: "NULL::smallint", value: "r.user_id", where: " and r.user_id IS NOT NULL and r.user_id <> ''"},
+}
+
+type AggregateQueries struct {
+	interval  aggregateInterval
+	dimension topDimension
+	from      time.Time
+	to        time.Time // Exclusive
+	limit     int
+	filters   DataFetchQueries // Filters other than dates, shared with data access
+}
+
+// getAggregateQueries reads the time range, interval and filters for an
+// aggregation from the request, defaulting to the most recent period covered
+// by the interval.
+func getAggregateQueries(c *gin.Context) (AggregateQueries, error) {
+	queries := AggregateQueries{interval: aggregateIntervals["hour"], limit: defaultTopLimit}
+	if intervalQuery := c.Query("interval"); intervalQuery != "" {
+		interval, ok := aggregateIntervals[intervalQuery]
+		if !ok {
+			return queries, errors.New("Invalid interval.")
+		}
+		queries.interval = interval
+	}
+
+	queries.to = time.Now().UTC()
+	if toQuery := c.Query("to"); toQuery != "" {
+		if queries.to = parseQueryDateTime(toQuery); queries.to.IsZero() {
+			return queries, errors.New("Invalid end date.")
+		}
+	}
+	queries.from = queries.to.Add(-queries.interval.defaultSpan)
+	if fromQuery := c.Query("from"); fromQuery != "" {
+		if queries.from = parseQueryDateTime(fromQuery); queries.from.IsZero() {
+			return queries, errors.New("Invalid start date.")
+		}
+	}
+	if !queries.from.Before(queries.to) {
+		return queries, errors.New("Start date must be before end date.")
+	}
+	if queries.to.Sub(queries.from) > time.Duration(maxAggregateBuckets)*queries.interval.step {
+		return queries, errors.New("Time range too large for interval.")
+	}
+
+	if limitQuery := c.Query("limit"); limitQuery != "" {
+		limit, err := strconv.Atoi(limitQuery)
+		if err != nil || limit <= 0 || limit > maxTopLimit {
+			return queries, errors.New("Invalid limit.")
+		}
+		queries.limit = limit
+	}
+
+	// Time range replaces the date filters used for data access
+	filters, err := getQueriesFromRequest(c)
+	if err != nil {
+		return queries, err
+	}
+	queries.filters = filters
+	queries.filters.date = time.Time{}
+	queries.filters.dateFrom = time.Time{}
+	queries.filters.dateTo = time.Time{}
+	return queries, nil
+}
+
+// writeAggregateRange appends the conditions selecting an account's requests
+// within the time range and matching the filters.
+func writeAggregateRange(query *strings.Builder, apiKey string, queries AggregateQueries) []any {
+	query.WriteString(" FROM requests r WHERE api_key = $1 and r.created_at >= $2 and r.created_at < $3")
+	arguments := []any{apiKey, queries.from, queries.to}
+	query.WriteString(queries.dimension.where)
+	return writeDataFilters(query, arguments, queries.filters)
+}
+
+// buildSeriesQuery counts requests and errors and finds response time
+// percentiles for each bucket in the time range. Sampled rows count as the
+// number of requests they represent, but the percentiles are unweighted, with
+// each sampled row counted once.
+func buildSeriesQuery(apiKey string, queries AggregateQueries) (string, []any) {
+	var query strings.Builder
+	query.WriteString(fmt.Sprintf("SELECT date_trunc('%s', r.created_at) AS bucket, sum(r.weight), coalesce(sum(r.weight) FILTER (WHERE r.status >= 400), 0), percentile_cont(0.5) WITHIN GROUP (ORDER BY r.response_time), percentile_cont(0.95) WITHIN GROUP (ORDER BY r.response_time), percentile_cont(0.99) WITHIN GROUP (ORDER BY r.response_time)", queries.interval.name))
+	arguments := writeAggregateRange(&query, apiKey, queries)
+	query.WriteString(" GROUP BY bucket ORDER BY bucket;")
+	return query.String(), arguments
+}
+
+// buildTopQuery counts requests and errors for the most frequent values of a
+// dimension in the time range. User agents are grouped by ID and only the
+// top IDs are joined to their names.
+func buildTopQuery(apiKey string, queries AggregateQueries) (string, []any) {
+	dimension := queries.dimension
+	var query strings.Builder
+	query.WriteString(fmt.Sprintf("SELECT %s AS method, %s AS value, sum(r.weight) AS requests, coalesce(sum(r.weight) FILTER (WHERE r.status >= 400), 0) AS errors", dimension.method, dimension.value))
+	arguments := writeAggregateRange(&query, apiKey, queries)
+	query.WriteString(fmt.Sprintf(" GROUP BY 1, 2 ORDER BY 3 DESC LIMIT $%d", len(arguments)+1))
+	arguments = append(arguments, queries.limit)
+
+	if dimension.lookup {
+		return "SELECT t.method, u.user_agent, t.requests, t.errors FROM (" + query.String() + ") t LEFT JOIN user_agents u ON u.id = t.value ORDER BY t.requests DESC;", arguments
+	}
+	query.WriteString(";")
+	return query.String(), arguments
+}
+
+type SeriesBucket struct {
+	Time      time.Time `json:"time"`
+	Requests  float64   `json:"requests"` // Estimated total including sampled out requests
+	Errors    float64   `json:"errors"`   // Requests with a 4xx or 5xx status
+	ErrorRate float64   `json:"error_rate"`
+	P50       float64   `json:"p50"` // Response time percentiles in milliseconds
+	P95       float64   `json:"p95"`
+	P99       float64   `json:"p99"`
+}
+
+func buildSeries(rows pgx.Rows) ([]SeriesBucket, error) {
+	series := make([]SeriesBucket, 0)
+	for rows.Next() {
+		var bucket SeriesBucket
+		err := rows.Scan(&bucket.Time, &bucket.Requests, &bucket.Errors, &bucket.P50, &bucket.P95, &bucket.P99)
+		if err != nil {
+			return nil, err
+		}
+		if bucket.Requests > 0 {
+			bucket.ErrorRate = bucket.Errors / bucket.Requests
+		}
+		bucket.P50 /= 1000
+		bucket.P95 /= 1000
+		bucket.P99 /= 1000
+		series = append(series, bucket)
+	}
+	return series, rows.Err()
+}
+
+type TopEntry struct {
+	Method   *int16  `json:"method,omitempty"` // Only set for endpoints
+	Value    *string `json:"value"`            // Null for requests without a value
+	Requests float64 `json:"requests"`
+	Errors   float64 `json:"errors"`
+}
+
+func buildTop(rows pgx.Rows) ([]TopEntry, error) {
+	top := make([]TopEntry, 0)
+	for rows.Next() {
+		var entry TopEntry
+		if err := rows.Scan(&entry.Method, &entry.Value, &entry.Requests, &entry.Errors); err != nil {
+			return nil, err
+		}
+		top = append(top, entry)
+	}
+	return top, rows.Err()
+}
+
+func getAggregateSeries(c *gin.Context) {
+	aggregate(c, "series", buildSeriesQuery, func(rows pgx.Rows) (any, error) { return buildSeries(rows) })
+}
+
+func getAggregateTop(c *gin.Context) {
+	aggregate(c, "top", buildTopQuery, func(rows pgx.Rows) (any, error) { return buildTop(rows) })
+}
+
+// aggregate runs an aggregation over the requests of the account belonging
+// to the user ID in the path, returning the result alongside the time range
+// covered.
+func aggregate(c *gin.Context, name string, buildQuery func(string, AggregateQueries) (string, []any), build func(pgx.Rows) (any, error)) {
+	var userID string = c.Param("userID")
+	if userID == "" {
+		log.LogToFile("User ID empty")
+		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Invalid user ID."})
+		return
+	}
+
+	queries, err := getAggregateQueries(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	if dimensionParam := c.Param("dimension"); dimensionParam != "" {
+		dimension, ok := topDimensions[dimensionParam]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Invalid dimension."})
+			return
+		}
+		queries.dimension = dimension
+	}
+
+	log.LogToFile(fmt.Sprintf("id=%s: Aggregate %s access", userID, name))
+
+	conn, err := pool.Acquire(c.Request.Context())
+	if err != nil {
+		databaseUnavailable(c, err)
+		return
+	}
+	defer conn.Release()
+	connection := conn.Conn()
+
+	apiKey, err := getUserAPIKey(connection, userID)
+	if err != nil {
+		log.LogToFile(fmt.Sprintf("id=%s: No API key associated with user ID - %s", userID, err.Error()))
+		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Invalid user ID."})
+		return
+	}
+
+	query, arguments := buildQuery(apiKey, queries)
+	rows, err := connection.Query(c.Request.Context(), query, arguments...)
+	if err != nil {
+		if database.IsUnavailable(err) {
+			databaseUnavailable(c, err)
+			return
+		}
+		log.LogToFile(fmt.Sprintf("key=%s: Aggregate %s failed - %s", apiKey, name, err.Error()))
+		c.JSON(http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": "Aggregation failed."})
+		return
+	}
+	result, err := build(rows)
+	rows.Close()
+	if err != nil {
+		if database.IsUnavailable(err) {
+			databaseUnavailable(c, err)
+			return
+		}
+		log.LogToFile(fmt.Sprintf("key=%s: Aggregate %s failed - %s", apiKey, name, err.Error()))
+		c.JSON(http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": "Aggregation failed."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"interval": queries.interval.name, "from": queries.from, "to": queries.to, name: result})
+
+	log.LogToFile(fmt.Sprintf("key=%s: Aggregate %s access successful", apiKey, name))
+
+	err = updateLastAccessed(connection, apiKey)
+	if err != nil {
+		log.LogToFile(fmt.Sprintf("key=%s: User last access update failed - %s", apiKey, err.Error()))
+	}
+}
@@ -0,0 +1,78 @@
+package routes
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newTestContext(target string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", target, nil)
+	return c
+}
+
+func TestGetAggregateQueries(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected bool
+	}{
+		{"", true},
+		{"?interval=minute", true},
+		{"?interval=day&from=2024-01-01&to=2024-03-01", true},
+		{"?interval=hour&from=2024-01-01T00:00:00Z&to=2024-01-01%2012:00:00", true},
+		{"?limit=100", true},
+		{"?interval=week", false},
+		{"?from=yesterday", false},
+		{"?from=2024-01-02&to=2024-01-01", false},
+		{"?interval=minute&from=2024-01-01&to=2024-01-03", false}, // Too many buckets
+		{"?limit=0", false},
+		{"?limit=101", false},
+	}
+
+	for i, test := range tests {
+		_, err := getAggregateQueries(newTestContext("/" + test.query))
+		if got := err == nil; got != test.expected {
+			t.Errorf("%d: got %t, expected %t (%v)", i, got, test.expected, err)
+		}
+	}
+}
+
+func TestGetAggregateQueriesDefaultRange(t *testing.T) {
+	queries, err := getAggregateQueries(newTestContext("/?interval=minute&date=2024-01-01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if span := queries.to.Sub(queries.from); span != time.Hour {
+		t.Errorf("got range of %s, expected 1h", span)
+	}
+	if !queries.filters.date.IsZero() {
+		t.Errorf("got date filter %s, expected none", queries.filters.date)
+	}
+}
+
+func TestBuildTopQuery(t *testing.T) {
+	c := newTestContext("/?status=500&limit=5")
+	queries, err := getAggregateQueries(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queries.dimension = topDimensions["user_agents"]
+	query, arguments := buildTopQuery("key", queries)
+	if !strings.Contains(query, "JOIN user_agents") || !strings.Contains(query, "r.status = $4") || !strings.Contains(query, "LIMIT $5") {
+		t.Errorf("got query %q", query)
+	}
+	if len(arguments) != 5 || arguments[3] != 500 || arguments[4] != 5 {
+		t.Errorf("got arguments %v", arguments)
+	}
+
+	queries.dimension = topDimensions["users"]
+	query, _ = buildTopQuery("key", queries)
+	if strings.Contains(query, "JOIN") || !strings.Contains(query, "r.user_id <> ''") {
+		t.Errorf("got query %q", query)
+	}
+}
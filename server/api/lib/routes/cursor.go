@@ -0,0 +1,64 @@
+package routes
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Maximum number of rows returned in a page of data
+const dataPageSize int = 50_000
+
+// Number of streamed rows written between flushes
+const streamFlushRows int = 1000
+
+// dataCursor is the position of the last row of a page, from which the next
+// page continues.
+type dataCursor struct {
+	createdAt time.Time
+	requestID int64
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// String encodes the cursor as an opaque URL-safe token.
+func (c dataCursor) String() string {
+	value := strconv.FormatInt(c.createdAt.UnixMicro(), 10) + "." + strconv.FormatInt(c.requestID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(value))
+}
+
+// parseDataCursor decodes a cursor token, returning nil if none was given.
+func parseDataCursor(token string) (*dataCursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+	value, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	micros, id, ok := strings.Cut(string(value), ".")
+	if !ok {
+		return nil, errInvalidCursor
+	}
+	createdAt, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	requestID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	return &dataCursor{createdAt: time.UnixMicro(createdAt).UTC(), requestID: requestID}, nil
+}
+
+// setNextCursor sets the X-Next-Cursor header to continue from the last row
+// if the page is full and more rows may follow.
+func setNextCursor(c *gin.Context, last *dataCursor, count int) {
+	if last != nil && count >= dataPageSize {
+		c.Header("X-Next-Cursor", last.String())
+	}
+}
@@ -0,0 +1,62 @@
+package routes
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDataCursorRoundTrip(t *testing.T) {
+	cursor := dataCursor{createdAt: time.Date(2024, 6, 1, 12, 30, 0, 123456000, time.UTC), requestID: 987654321}
+	parsed, err := parseDataCursor(cursor.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.createdAt.Equal(cursor.createdAt) || parsed.requestID != cursor.requestID {
+		t.Errorf("got %+v, expected %+v", *parsed, cursor)
+	}
+}
+
+func TestParseDataCursor(t *testing.T) {
+	tests := []struct {
+		token    string
+		expected bool
+	}{
+		{"", true},
+		{"not base64!", false},
+		{base64.RawURLEncoding.EncodeToString([]byte("1717245000000000")), false},
+		{base64.RawURLEncoding.EncodeToString([]byte("abc.1")), false},
+		{base64.RawURLEncoding.EncodeToString([]byte("1717245000000000.1")), true},
+	}
+
+	for i, test := range tests {
+		_, err := parseDataCursor(test.token)
+		if got := err == nil; got != test.expected {
+			t.Errorf("%d: got %t, expected %t", i, got, test.expected)
+		}
+	}
+}
+
+func TestBuildDataFetchQuery(t *testing.T) {
+	queries := DataFetchQueries{page: 3, filters: []dataFilter{{column: "r.status", include: []filterMatch{{value: 404}}}}}
+	query, _ := buildDataFetchQuery("key", queries)
+	if !strings.HasSuffix(query, "ORDER BY r.created_at, r.request_id LIMIT 50000 OFFSET 100000;") {
+		t.Errorf("got query %q", query)
+	}
+
+	queries.cursor = &dataCursor{createdAt: time.Now(), requestID: 10}
+	query, arguments := buildDataFetchQuery("key", queries)
+	if !strings.Contains(query, "(r.created_at, r.request_id) > ($3, $4)") || !strings.HasSuffix(query, "LIMIT 50000;") {
+		t.Errorf("got query %q", query)
+	}
+	if len(arguments) != 4 || arguments[3] != int64(10) {
+		t.Errorf("got arguments %v", arguments)
+	}
+
+	queries.format = formatCSV
+	query, _ = buildDataFetchQuery("key", queries)
+	if strings.Contains(query, "LIMIT") {
+		t.Errorf("got query %q, expected no limit when streaming", query)
+	}
+}
@@ -0,0 +1,300 @@
+package routes
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/parquet-go/parquet-go"
+	"github.com/tom-draper/api-analytics/server/database"
+)
+
+// Formats requests can be exported in, streamed rather than paged
+const (
+	formatCSV     string = "csv"
+	formatNDJSON  string = "ndjson"
+	formatParquet string = "parquet"
+)
+
+var exportContentTypes = map[string]string{
+	formatCSV:     "text/csv",
+	formatNDJSON:  "application/x-ndjson",
+	formatParquet: "application/vnd.apache.parquet",
+}
+
+// Maximum rows buffered in memory for each Parquet row group
+const parquetRowGroupSize int64 = 100_000
+
+var errUnsupportedFormat = errors.New("unsupported format")
+
+// getExportFormat returns the export format given by the format query or
+// negotiated from the Accept header, or an empty string for JSON.
+func getExportFormat(c *gin.Context) (string, error) {
+	format := c.Query("format")
+	if format == "" && c.Query("stream") == "true" {
+		format = formatNDJSON
+	}
+	switch format {
+	case "json":
+		return "", nil
+	case "":
+		negotiated := c.NegotiateFormat("application/json", exportContentTypes[formatCSV], exportContentTypes[formatNDJSON], exportContentTypes[formatParquet])
+		for name, contentType := range exportContentTypes {
+			if negotiated == contentType {
+				return name, nil
+			}
+		}
+		return "", nil
+	}
+	if _, ok := exportContentTypes[format]; !ok {
+		return "", errUnsupportedFormat
+	}
+	return format, nil
+}
+
+// ExportRow is a request as exported, with the method as its verb and the
+// time in RFC 3339 for loading into other tools.
+type ExportRow struct {
+	IPAddress    string            `json:"ip_address" parquet:"ip_address"`
+	Path         string            `json:"path" parquet:"path"`
+	Hostname     string            `json:"hostname" parquet:"hostname"`
+	UserAgent    string            `json:"user_agent" parquet:"user_agent"`
+	Method       string            `json:"method" parquet:"method"`
+	ResponseTime float64           `json:"response_time" parquet:"response_time"` // Milliseconds
+	Status       int32             `json:"status" parquet:"status"`
+	Location     string            `json:"location" parquet:"location"`
+	UserID       string            `json:"user_id" parquet:"user_id"`
+	CreatedAt    string            `json:"created_at" parquet:"created_at"`
+	RequestSize  *int64            `json:"request_size" parquet:"request_size,optional"`
+	ResponseSize *int64            `json:"response_size" parquet:"response_size,optional"`
+	Protocol     *int32            `json:"protocol" parquet:"protocol,optional"`
+	TLS          bool              `json:"tls" parquet:"tls"`
+	Tags         map[string]string `json:"tags" parquet:"tags,optional"`
+	ErrorClass   *int32            `json:"error_class" parquet:"error_class,optional"`
+	ErrorMessage *string           `json:"error_message" parquet:"error_message,optional"`
+	Region       *string           `json:"region" parquet:"region,optional"`
+	City         *string           `json:"city" parquet:"city,optional"`
+	ASN          *int64            `json:"asn" parquet:"asn,optional"`
+	ISP          *string           `json:"isp" parquet:"isp,optional"`
+}
+
+// Verb of each method ID stored in the requests table
+var methodNames = func() map[int16]string {
+	names := make(map[int16]string, len(database.MethodID))
+	for name, id := range database.MethodID {
+		names[id] = name
+	}
+	return names
+}()
+
+func newExportRow(request *RequestRow) ExportRow {
+	data := newRequestData(request)
+	return ExportRow{
+		IPAddress:    data.IPAddress,
+		Path:         data.Path,
+		Hostname:     data.Hostname,
+		UserAgent:    data.UserAgent,
+		Method:       methodNames[data.Method],
+		ResponseTime: data.ResponseTime,
+		Status:       int32(data.Status),
+		Location:     data.Location,
+		UserID:       data.UserID,
+		CreatedAt:    data.CreatedAt.UTC().Format(time.RFC3339),
+		RequestSize:  data.RequestSize,
+		ResponseSize: data.ResponseSize,
+		Protocol:     widenNullable(data.Protocol),
+		TLS:          data.TLS,
+		Tags:         data.Tags,
+		ErrorClass:   widenNullable(data.ErrorClass),
+		ErrorMessage: data.ErrorMessage,
+		Region:       data.Region,
+		City:         data.City,
+		ASN:          data.ASN,
+		ISP:          data.ISP,
+	}
+}
+
+// widenNullable converts a nullable int16 column to the int32 that Parquet
+// stores small integers as.
+func widenNullable(value *int16) *int32 {
+	if value == nil {
+		return nil
+	}
+	widened := int32(*value)
+	return &widened
+}
+
+// exportWriter encodes exported rows to a response.
+type exportWriter interface {
+	Write(row ExportRow) error
+	Flush() error
+	Close() error
+}
+
+func newExportWriter(w io.Writer, format string) (exportWriter, error) {
+	switch format {
+	case formatCSV:
+		return newCSVWriter(w)
+	case formatNDJSON:
+		return &ndjsonWriter{encoder: json.NewEncoder(w)}, nil
+	case formatParquet:
+		return &parquetWriter{writer: parquet.NewGenericWriter[ExportRow](w, parquet.MaxRowsPerRowGroup(parquetRowGroupSize))}, nil
+	}
+	return nil, errUnsupportedFormat
+}
+
+type ndjsonWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *ndjsonWriter) Write(row ExportRow) error {
+	return w.encoder.Encode(row)
+}
+
+func (w *ndjsonWriter) Flush() error { return nil }
+
+func (w *ndjsonWriter) Close() error { return nil }
+
+var csvColumns = []string{"ip_address", "path", "hostname", "user_agent", "method", "response_time", "status", "location", "user_id", "created_at", "request_size", "response_size", "protocol", "tls", "tags", "error_class", "error_message", "region", "city", "asn", "isp"}
+
+type csvWriter struct {
+	writer *csv.Writer
+	record []string
+}
+
+func newCSVWriter(w io.Writer) (*csvWriter, error) {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvColumns); err != nil {
+		return nil, err
+	}
+	return &csvWriter{writer: writer, record: make([]string, len(csvColumns))}, nil
+}
+
+// Write adds a row as a CSV record, with null values as empty fields and
+// tags as a JSON object.
+func (w *csvWriter) Write(row ExportRow) error {
+	var tags string
+	if len(row.Tags) > 0 {
+		data, err := json.Marshal(row.Tags)
+		if err != nil {
+			return err
+		}
+		tags = string(data)
+	}
+	w.record = append(w.record[:0],
+		row.IPAddress,
+		row.Path,
+		row.Hostname,
+		row.UserAgent,
+		row.Method,
+		strconv.FormatFloat(row.ResponseTime, 'f', -1, 64),
+		strconv.Itoa(int(row.Status)),
+		row.Location,
+		row.UserID,
+		row.CreatedAt,
+		formatNullable(row.RequestSize),
+		formatNullable(row.ResponseSize),
+		formatNullable(row.Protocol),
+		strconv.FormatBool(row.TLS),
+		tags,
+		formatNullable(row.ErrorClass),
+		formatNullable(row.ErrorMessage),
+		formatNullable(row.Region),
+		formatNullable(row.City),
+		formatNullable(row.ASN),
+		formatNullable(row.ISP),
+	)
+	return w.writer.Write(w.record)
+}
+
+func (w *csvWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func (w *csvWriter) Close() error {
+	return w.Flush()
+}
+
+func formatNullable[T any](value *T) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(*value)
+}
+
+// parquetWriter buffers rows into row groups, writing each group to the
+// response once full. The file footer is written on Close.
+type parquetWriter struct {
+	writer *parquet.GenericWriter[ExportRow]
+	rows   []ExportRow
+}
+
+func (w *parquetWriter) Write(row ExportRow) error {
+	w.rows = append(w.rows, row)
+	if len(w.rows) >= streamFlushRows {
+		return w.writeRows()
+	}
+	return nil
+}
+
+func (w *parquetWriter) writeRows() error {
+	_, err := w.writer.Write(w.rows)
+	w.rows = w.rows[:0]
+	return err
+}
+
+func (w *parquetWriter) Flush() error {
+	return w.writeRows()
+}
+
+func (w *parquetWriter) Close() error {
+	if err := w.writeRows(); err != nil {
+		return err
+	}
+	return w.writer.Close()
+}
+
+// exportRequestData writes the requests in an export format as they are read,
+// flushing regularly so that the response is sent in chunks rather than held
+// in memory. Returns the number of rows written.
+func exportRequestData(c *gin.Context, rows pgx.Rows, format string) (int, error) {
+	c.Header("Content-Type", exportContentTypes[format])
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="requests.%s"`, format))
+	c.Status(http.StatusOK)
+
+	writer, err := newExportWriter(c.Writer, format)
+	if err != nil {
+		return 0, err
+	}
+
+	var request RequestRow
+	var count int
+	for rows.Next() {
+		if err := scanRequestRow(rows, &request); err != nil {
+			continue
+		}
+		if err := writer.Write(newExportRow(&request)); err != nil {
+			return count, err
+		}
+		count++
+		if count%streamFlushRows == 0 {
+			if err := writer.Flush(); err != nil {
+				return count, err
+			}
+			c.Writer.Flush()
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return count, err
+	}
+	c.Writer.Flush()
+	return count, rows.Err()
+}
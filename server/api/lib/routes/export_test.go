@@ -0,0 +1,147 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/tom-draper/api-analytics/server/database"
+)
+
+func TestGetExportFormat(t *testing.T) {
+	tests := []struct {
+		query    string
+		accept   string
+		expected string
+		valid    bool
+	}{
+		{"", "", "", true},
+		{"?format=json", "", "", true},
+		{"?format=csv", "", formatCSV, true},
+		{"?format=parquet", "application/json", formatParquet, true},
+		{"?stream=true", "", formatNDJSON, true},
+		{"", "text/csv", formatCSV, true},
+		{"", "application/x-ndjson, application/json;q=0.5", formatNDJSON, true},
+		{"", "*/*", "", true},
+		{"?format=xml", "", "", false},
+	}
+
+	for i, test := range tests {
+		c := newTestContext("/" + test.query)
+		if test.accept != "" {
+			c.Request.Header.Set("Accept", test.accept)
+		}
+		got, err := getExportFormat(c)
+		if (err == nil) != test.valid || got != test.expected {
+			t.Errorf("%d: got %q (%v), expected %q", i, got, err, test.expected)
+		}
+	}
+}
+
+func newTestExportRows() []ExportRow {
+	hostname := "example.com"
+	size := int64(120)
+	return []ExportRow{
+		newExportRow(&RequestRow{
+			Hostname:     &hostname,
+			Path:         "/users",
+			Method:       database.MethodID["POST"],
+			Status:       201,
+			ResponseTime: 1500,
+			CreatedAt:    time.Date(2024, 6, 1, 12, 0, 0, 0, time.FixedZone("BST", 3600)),
+			ResponseSize: &size,
+			Tags:         map[string]string{"region": "eu-west"},
+		}),
+		newExportRow(&RequestRow{Path: "/", Method: database.MethodID["GET"], Status: 200}),
+	}
+}
+
+func TestNewExportRow(t *testing.T) {
+	row := newTestExportRows()[0]
+	if row.Method != "POST" || row.CreatedAt != "2024-06-01T11:00:00Z" || row.ResponseTime != 1.5 || row.Hostname != "example.com" {
+		t.Errorf("got %+v", row)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	var buffer bytes.Buffer
+	writer, err := newExportWriter(&buffer, formatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range newTestExportRows() {
+		if err := writer.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&buffer).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || len(records[1]) != len(csvColumns) {
+		t.Fatalf("got %d records, expected 3", len(records))
+	}
+	expected := map[string]string{"method": "POST", "created_at": "2024-06-01T11:00:00Z", "response_size": "120", "request_size": "", "tags": `{"region":"eu-west"}`}
+	for i, column := range csvColumns {
+		if value, ok := expected[column]; ok && records[1][i] != value {
+			t.Errorf("got %s %q, expected %q", column, records[1][i], value)
+		}
+	}
+}
+
+func TestExportNDJSON(t *testing.T) {
+	var buffer bytes.Buffer
+	writer, _ := newExportWriter(&buffer, formatNDJSON)
+	for _, row := range newTestExportRows() {
+		writer.Write(row)
+	}
+	writer.Close()
+
+	decoder := json.NewDecoder(&buffer)
+	var count int
+	for decoder.More() {
+		var row map[string]any
+		if err := decoder.Decode(&row); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := row["method"].(string); !ok {
+			t.Errorf("%d: got method %v, expected verb", count, row["method"])
+		}
+		count++
+	}
+	if count != 2 {
+		t.Errorf("got %d rows, expected 2", count)
+	}
+}
+
+func TestExportParquet(t *testing.T) {
+	var buffer bytes.Buffer
+	writer, _ := newExportWriter(&buffer, formatParquet)
+	rows := newTestExportRows()
+	for _, row := range rows {
+		if err := writer.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := parquet.Read[ExportRow](bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(rows) {
+		t.Fatalf("got %d rows, expected %d", len(got), len(rows))
+	}
+	if got[0].Method != "POST" || got[0].ResponseSize == nil || *got[0].ResponseSize != 120 || got[0].Tags["region"] != "eu-west" || got[1].ResponseSize != nil {
+		t.Errorf("got %+v", got)
+	}
+}
@@ -0,0 +1,218 @@
+package routes
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tom-draper/api-analytics/server/database"
+)
+
+// Maximum number of values a single filter can be given
+const maxFilterValues int = 100
+
+// filterMatch is a single value of a filter, either compared for equality
+// with the column or matched by a condition.
+type filterMatch struct {
+	value     any    // Compared for equality if there is no condition
+	condition string // Format given the column followed by a placeholder for each argument
+	arguments []any
+}
+
+// dataFilter matches rows whose column matches any of the included values
+// and none of the excluded values.
+type dataFilter struct {
+	column  string
+	include []filterMatch
+	exclude []filterMatch
+}
+
+// dataFilterParam is a query parameter that filters requests by a column.
+type dataFilterParam struct {
+	name   string
+	column string
+	parse  func(value string) (filterMatch, bool)
+}
+
+var dataFilterParams = []dataFilterParam{
+	{name: "hostname", column: "r.hostname", parse: parseEqualFilter(database.ValidHostname)},
+	{name: "ip", column: "r.ip_address", parse: parseEqualFilter(database.ValidIPAddress)},
+	{name: "location", column: "r.location", parse: parseEqualFilter(database.ValidLocation)},
+	{name: "region", column: "r.region", parse: parseEqualFilter(database.ValidString)},
+	{name: "city", column: "r.city", parse: parseEqualFilter(database.ValidString)},
+	{name: "asn", column: "r.asn", parse: parseASNFilter},
+	{name: "isp", column: "r.isp", parse: parseEqualFilter(database.ValidString)},
+	{name: "userID", column: "r.user_id", parse: parseEqualFilter(database.ValidUserID)},
+	{name: "status", column: "r.status", parse: parseStatusFilter},
+	{name: "method", column: "r.method", parse: parseMethodFilter},
+	{name: "path", column: "r.path", parse: parsePathFilter},
+	{name: "userAgent", column: "r.user_agent_id", parse: parseUserAgentFilter},
+	{name: "responseTimeMin", column: "r.response_time", parse: parseResponseTimeFilter("%[1]s >= %[2]s")},
+	{name: "responseTimeMax", column: "r.response_time", parse: parseResponseTimeFilter("%[1]s <= %[2]s")},
+}
+
+// getDataFilters reads the column filters from the request. Each parameter
+// can be repeated to match any of its values, and values prefixed with ! are
+// excluded instead.
+func getDataFilters(c *gin.Context) ([]dataFilter, error) {
+	filters := make([]dataFilter, 0)
+	for _, param := range dataFilterParams {
+		values := c.QueryArray(param.name)
+		if len(values) > maxFilterValues {
+			return nil, fmt.Errorf("Too many %s filters.", param.name)
+		}
+
+		filter := dataFilter{column: param.column}
+		for _, value := range values {
+			if value == "" {
+				continue
+			}
+			excluded := strings.HasPrefix(value, "!")
+			match, ok := param.parse(strings.TrimPrefix(value, "!"))
+			if !ok {
+				return nil, fmt.Errorf("Invalid %s filter %q.", param.name, value)
+			}
+			if excluded {
+				filter.exclude = append(filter.exclude, match)
+			} else {
+				filter.include = append(filter.include, match)
+			}
+		}
+		if len(filter.include) > 0 || len(filter.exclude) > 0 {
+			filters = append(filters, filter)
+		}
+	}
+	return filters, nil
+}
+
+func parseEqualFilter(valid func(string) bool) func(string) (filterMatch, bool) {
+	return func(value string) (filterMatch, bool) {
+		return filterMatch{value: value}, valid(value)
+	}
+}
+
+func parseASNFilter(value string) (filterMatch, bool) {
+	asn, err := strconv.ParseInt(value, 10, 64)
+	return filterMatch{value: asn}, err == nil && asn > 0
+}
+
+// parseStatusFilter matches a status code (404), a class of status codes
+// (5xx) or an inclusive range (400-499).
+func parseStatusFilter(value string) (filterMatch, bool) {
+	if len(value) == 3 && strings.EqualFold(value[1:], "xx") && value[0] >= '1' && value[0] <= '5' {
+		class := int(value[0]-'0') * 100
+		return filterMatch{condition: "%[1]s BETWEEN %[2]s AND %[3]s", arguments: []any{class, class + 99}}, true
+	}
+	if from, to, ok := strings.Cut(value, "-"); ok {
+		lower, err := strconv.Atoi(from)
+		if err != nil || !database.ValidStatus(lower) {
+			return filterMatch{}, false
+		}
+		upper, err := strconv.Atoi(to)
+		if err != nil || !database.ValidStatus(upper) || upper < lower {
+			return filterMatch{}, false
+		}
+		return filterMatch{condition: "%[1]s BETWEEN %[2]s AND %[3]s", arguments: []any{lower, upper}}, true
+	}
+	status, err := strconv.Atoi(value)
+	return filterMatch{value: status}, err == nil && database.ValidStatus(status)
+}
+
+func parseMethodFilter(value string) (filterMatch, bool) {
+	method, ok := database.MethodID[strings.ToUpper(value)]
+	return filterMatch{value: method}, ok
+}
+
+// parsePathFilter matches a path exactly, or as a glob pattern if it
+// contains a * wildcard, e.g. /api/* for all paths under /api/.
+func parsePathFilter(value string) (filterMatch, bool) {
+	if !database.ValidPath(value) {
+		return filterMatch{}, false
+	}
+	if !strings.Contains(value, "*") {
+		return filterMatch{value: value}, true
+	}
+	pattern := strings.ReplaceAll(escapeLike(value), "*", "%")
+	return filterMatch{condition: "%[1]s LIKE %[2]s", arguments: []any{pattern}}, true
+}
+
+// parseUserAgentFilter matches user agents containing the value, ignoring
+// case.
+func parseUserAgentFilter(value string) (filterMatch, bool) {
+	if !database.ValidUserAgent(value) {
+		return filterMatch{}, false
+	}
+	pattern := "%" + escapeLike(value) + "%"
+	return filterMatch{condition: "%[1]s IN (SELECT id FROM user_agents WHERE user_agent ILIKE %[2]s)", arguments: []any{pattern}}, true
+}
+
+// parseResponseTimeFilter compares the response time with a threshold given
+// in milliseconds.
+func parseResponseTimeFilter(condition string) func(string) (filterMatch, bool) {
+	return func(value string) (filterMatch, bool) {
+		milliseconds, err := strconv.ParseFloat(value, 64)
+		if err != nil || milliseconds < 0 || math.IsInf(milliseconds, 0) {
+			return filterMatch{}, false
+		}
+		// Response times are stored in microseconds
+		microseconds := int64(math.Round(milliseconds * 1000))
+		return filterMatch{condition: condition, arguments: []any{microseconds}}, true
+	}
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// escapeLike escapes the wildcard characters of a LIKE pattern.
+func escapeLike(value string) string {
+	return likeEscaper.Replace(value)
+}
+
+// write appends the conditions of the filter, returning the arguments
+// extended with their values. Excluded values also keep rows where the
+// column is null.
+func (f dataFilter) write(query *strings.Builder, arguments []any) []any {
+	if len(f.include) > 0 {
+		var condition string
+		condition, arguments = writeFilterMatches(f.column, f.include, arguments)
+		query.WriteString(" and " + condition)
+	}
+	if len(f.exclude) > 0 {
+		var condition string
+		condition, arguments = writeFilterMatches(f.column, f.exclude, arguments)
+		query.WriteString(" and NOT coalesce(" + condition + ", false)")
+	}
+	return arguments
+}
+
+// writeFilterMatches returns a condition true if any of the matches hold,
+// with values compared for equality combined into a single IN list.
+func writeFilterMatches(column string, matches []filterMatch, arguments []any) (string, []any) {
+	var placeholders []string
+	var conditions []string
+	for _, match := range matches {
+		if match.condition == "" {
+			arguments = append(arguments, match.value)
+			placeholders = append(placeholders, fmt.Sprintf("$%d", len(arguments)))
+			continue
+		}
+		formatArguments := []any{column}
+		for _, argument := range match.arguments {
+			arguments = append(arguments, argument)
+			formatArguments = append(formatArguments, fmt.Sprintf("$%d", len(arguments)))
+		}
+		conditions = append(conditions, fmt.Sprintf(match.condition, formatArguments...))
+	}
+
+	if len(placeholders) == 1 {
+		conditions = append([]string{column + " = " + placeholders[0]}, conditions...)
+	} else if len(placeholders) > 1 {
+		conditions = append([]string{column + " IN (" + strings.Join(placeholders, ", ") + ")"}, conditions...)
+	}
+
+	if len(conditions) == 1 {
+		return conditions[0], arguments
+	}
+	return "(" + strings.Join(conditions, " or ") + ")", arguments
+}
@@ -0,0 +1,89 @@
+package routes
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetQueriesFromRequest(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected bool
+	}{
+		{"", true},
+		{"?status=404&status=5xx&status=!503", true},
+		{"?status=400-499", true},
+		{"?status=6xx", false},
+		{"?status=499-400", false},
+		{"?status=abc", false},
+		{"?method=get&method=!DELETE", true},
+		{"?method=FETCH", false},
+		{"?path=/api/*&path=!/api/health", true},
+		{"?userAgent=curl", true},
+		{"?responseTimeMin=250.5&responseTimeMax=1000", true},
+		{"?responseTimeMin=-1", false},
+		{"?ip=127.0.0.1&ip=::1", true},
+		{"?ip=localhost", false},
+		{"?location=GB&location=!US", true},
+		{"?location=gb", false},
+		{"?asn=0", false},
+		{"?dateFrom=2024-01-01T09:30:00Z&dateTo=2024-01-01%2017:00:00", true},
+		{"?dateFrom=yesterday", false},
+		{"?date=2024-13-01", false},
+		{"?hostname=", true},
+	}
+
+	for i, test := range tests {
+		_, err := getQueriesFromRequest(newTestContext("/" + test.query))
+		if got := err == nil; got != test.expected {
+			t.Errorf("%d: got %t, expected %t (%v)", i, got, test.expected, err)
+		}
+	}
+}
+
+func TestTooManyFilterValues(t *testing.T) {
+	target := "/?hostname=a" + strings.Repeat("&hostname=a", maxFilterValues)
+	if _, err := getQueriesFromRequest(newTestContext(target)); err == nil {
+		t.Error("got no error, expected too many filters")
+	}
+}
+
+func TestWriteDataFilters(t *testing.T) {
+	tests := []struct {
+		query     string
+		condition string
+		arguments []any
+	}{
+		{"?status=404", " and r.status = $2", []any{404}},
+		{"?status=404&status=410&status=5xx", " and (r.status IN ($2, $3) or r.status BETWEEN $4 AND $5)", []any{404, 410, 500, 599}},
+		{"?status=!5xx", " and NOT coalesce(r.status BETWEEN $2 AND $3, false)", []any{500, 599}},
+		{"?method=post", " and r.method = $2", []any{int16(1)}},
+		{"?path=/api/*&path=!/api/health", " and r.path LIKE $2 and NOT coalesce(r.path = $3, false)", []any{"/api/%", "/api/health"}},
+		{"?path=/50%25_off/*", " and r.path LIKE $2", []any{`/50\%\_off/%`}},
+		{"?userAgent=curl", " and r.user_agent_id IN (SELECT id FROM user_agents WHERE user_agent ILIKE $2)", []any{"%curl%"}},
+		{"?responseTimeMin=1.5", " and r.response_time >= $2", []any{int64(1500)}},
+	}
+
+	for i, test := range tests {
+		queries, err := getQueriesFromRequest(newTestContext("/" + test.query))
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		var query strings.Builder
+		arguments := writeDataFilters(&query, []any{"key"}, queries)
+		if query.String() != test.condition {
+			t.Errorf("%d: got %q, expected %q", i, query.String(), test.condition)
+		}
+		if len(arguments) != len(test.arguments)+1 {
+			t.Errorf("%d: got arguments %v, expected %v", i, arguments[1:], test.arguments)
+			continue
+		}
+		for j, argument := range test.arguments {
+			if arguments[j+1] != argument {
+				t.Errorf("%d: got arguments %v, expected %v", i, arguments[1:], test.arguments)
+				break
+			}
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -19,14 +20,19 @@ import (
 )
 
 func genAPIKey(c *gin.Context) {
-	connection := database.NewConnection()
-	defer connection.Close(context.Background())
+	conn, err := pool.Acquire(c.Request.Context())
+	if err != nil {
+		databaseUnavailable(c, err)
+		return
+	}
+	defer conn.Release()
+	connection := conn.Conn()
 
 	// Fetch all API request data associated with this account
 	query := "INSERT INTO users (api_key, user_id, created_at, last_accessed) VALUES (gen_random_uuid(), gen_random_uuid(), NOW(), NOW()) RETURNING api_key;"
 
 	var apiKey string
-	err := connection.QueryRow(context.Background(), query).Scan(&apiKey)
+	err = connection.QueryRow(context.Background(), query).Scan(&apiKey)
 	if err != nil {
 		log.LogToFile(fmt.Sprintf("API key generation failed - %s", err.Error()))
 		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "API key generation failed."})
@@ -47,13 +53,18 @@ func getUserID(c *gin.Context) {
 		return
 	}
 
-	connection := database.NewConnection()
-	defer connection.Close(context.Background())
+	conn, err := pool.Acquire(c.Request.Context())
+	if err != nil {
+		databaseUnavailable(c, err)
+		return
+	}
+	defer conn.Release()
+	connection := conn.Conn()
 
 	// Fetch user ID corresponding with API key
 	var userID string
 	query := "SELECT user_id FROM users WHERE api_key = $1;"
-	err := connection.QueryRow(context.Background(), query, apiKey).Scan(&userID)
+	err = connection.QueryRow(context.Background(), query, apiKey).Scan(&userID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Invalid API key."})
 		return
@@ -65,22 +76,24 @@ func getUserID(c *gin.Context) {
 
 type DashboardData struct {
 	UserAgents UserAgentsLookup `json:"user_agents"`
-	Requests   [][10]any        `json:"requests"`
+	Requests   [][11]any        `json:"requests"`
 }
 
 type UserAgentsLookup map[int]string
 
 type DashboardRequestRow struct {
+	RequestID    int64       `json:"request_id"`
 	Hostname     *string     `json:"hostname"` // Nullable
 	IPAddress    pgtype.CIDR `json:"ip_address"`
 	Path         string      `json:"path"`
 	UserAgent    *int        `json:"user_agent"` // Nullable
 	Method       int16       `json:"method"`
 	Status       int16       `json:"status"`
-	ResponseTime int16       `json:"response_time"`
-	Location     *string     `json:"location"` // Nullable
-	UserID       *string     `json:"user_id"`  // Nullable, custom user identifier field specific to each API service
+	ResponseTime int32       `json:"response_time"` // Microseconds
+	Location     *string     `json:"location"`      // Nullable
+	UserID       *string     `json:"user_id"`       // Nullable, custom user identifier field specific to each API service
 	CreatedAt    time.Time   `json:"created_at"`
+	ErrorClass   *int16      `json:"error_class"` // Nullable
 }
 
 func getRequests(c *gin.Context) {
@@ -93,8 +106,13 @@ func getRequests(c *gin.Context) {
 
 	log.LogToFile(fmt.Sprintf("id=%s: Dashboard access", userID))
 
-	connection := database.NewConnection()
-	defer connection.Close(context.Background())
+	conn, err := pool.Acquire(c.Request.Context())
+	if err != nil {
+		databaseUnavailable(c, err)
+		return
+	}
+	defer conn.Release()
+	connection := conn.Conn()
 
 	// Fetch API key corresponding with user ID
 	apiKey, err := getUserAPIKey(connection, userID)
@@ -104,58 +122,38 @@ func getRequests(c *gin.Context) {
 		return
 	}
 
-	requests := [][10]any{}
 	pageSize := 1_000_000
-	maxRequests := pageSize   // Temporary limit to prevent memory issues
-	pageMarker := time.Time{} // Start with min time to capture first page
-	userAgentIDs := make(map[int]struct{})
+	maxRequests := pageSize // Temporary limit to prevent memory issues
 
-	// Read paginated requests data
-	for {
-		// Left table join was originally used but often exceeded postgresql working memory limit with large numbers of requests
-		query := "SELECT ip_address, path, hostname, user_agent_id, method, response_time, status, location, user_id, created_at FROM requests WHERE api_key = $1 AND created_at >= $2 ORDER BY created_at LIMIT $3;"
-		rows, err := connection.Query(context.Background(), query, apiKey, pageMarker, pageSize)
+	// Left table join was originally used but often exceeded postgresql working memory limit with large numbers of requests
+	fetchPage := func(after dataCursor, limit int, read func(*DashboardRequestRow)) error {
+		query := "SELECT request_id, ip_address, path, hostname, user_agent_id, method, response_time, status, location, user_id, created_at, error_class FROM requests WHERE api_key = $1 AND (created_at, request_id) > ($2, $3) ORDER BY created_at, request_id LIMIT $4;"
+		rows, err := connection.Query(context.Background(), query, apiKey, after.createdAt, after.requestID, limit)
 		if err != nil {
-			log.LogToFile(fmt.Sprintf("key=%s: Invalid API key - %s", apiKey, err.Error()))
-			c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Invalid user ID."})
-			return
+			return err
 		}
+		defer rows.Close()
 
-		// First value in list holds column names
-		request := new(DashboardRequestRow)
-		var count int
+		request := new(DashboardRequestRow) // Reuseable request struct
 		for rows.Next() {
-			err := rows.Scan(&request.IPAddress, &request.Path, &request.Hostname, &request.UserAgent, &request.Method, &request.ResponseTime, &request.Status, &request.Location, &request.UserID, &request.CreatedAt)
-			if err == nil {
-				var ip string
-				if request.IPAddress.IPNet != nil {
-					ip = request.IPAddress.IPNet.IP.String()
-				}
-				hostname := getNullableString(request.Hostname)
-				location := getNullableString(request.Location)
-				userID := getNullableString(request.UserID)
-				requests = append(requests, [10]any{ip, request.Path, hostname, request.UserAgent, request.Method, request.ResponseTime, request.Status, location, userID, request.CreatedAt})
-				if request.UserAgent != nil {
-					if _, ok := userAgentIDs[*request.UserAgent]; !ok {
-						userAgentIDs[*request.UserAgent] = struct{}{}
-					}
-				}
-			}
-			count++
-			if count >= maxRequests {
-				break
+			err := rows.Scan(&request.RequestID, &request.IPAddress, &request.Path, &request.Hostname, &request.UserAgent, &request.Method, &request.ResponseTime, &request.Status, &request.Location, &request.UserID, &request.CreatedAt, &request.ErrorClass)
+			if err != nil {
+				return err
 			}
+			read(request)
 		}
-		// If haven't reached page size, there are no more rows to read
-		if count <= pageSize || count >= maxRequests {
-			rows.Close()
-			break
+		return rows.Err()
+	}
+
+	requests, userAgentIDs, err := readRequestPages(fetchPage, pageSize, maxRequests)
+	if err != nil {
+		log.LogToFile(fmt.Sprintf("key=%s: Failed to read requests - %s", apiKey, err.Error()))
+		if database.IsUnavailable(err) {
+			databaseUnavailable(c, err)
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": "Failed to read requests."})
 		}
-		// Save the final row's timestamp to know where next page begins
-		lastIdx := len(requests) - 1
-		lastTimestamp := requests[lastIdx][len(requests[lastIdx])-1].(time.Time)
-		pageMarker = lastTimestamp
-		rows.Close()
+		return
 	}
 
 	// Convert user agent IDs to names
@@ -195,6 +193,47 @@ func getRequests(c *gin.Context) {
 	}
 }
 
+// readRequestPages reads the requests returned by fetchPage a page at a time,
+// each page continuing after the last row of the previous one, until a page
+// is not full or maxRequests rows have been read.
+func readRequestPages(fetchPage func(after dataCursor, limit int, read func(*DashboardRequestRow)) error, pageSize int, maxRequests int) ([][11]any, map[int]struct{}, error) {
+	requests := [][11]any{}
+	userAgentIDs := make(map[int]struct{})
+	var after dataCursor // Zero cursor starts from the first row
+	for {
+		limit := min(pageSize, maxRequests-len(requests))
+		var count int
+		err := fetchPage(after, limit, func(request *DashboardRequestRow) {
+			requests = append(requests, newDashboardRequest(request))
+			if request.UserAgent != nil {
+				userAgentIDs[*request.UserAgent] = struct{}{}
+			}
+			after = dataCursor{createdAt: request.CreatedAt, requestID: request.RequestID}
+			count++
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		// If haven't reached page size, there are no more rows to read
+		if count < limit || len(requests) >= maxRequests {
+			return requests, userAgentIDs, nil
+		}
+	}
+}
+
+// newDashboardRequest returns the values of a request in the order the
+// dashboard reads them.
+func newDashboardRequest(request *DashboardRequestRow) [11]any {
+	var ip string
+	if request.IPAddress.IPNet != nil {
+		ip = request.IPAddress.IPNet.IP.String()
+	}
+	hostname := getNullableString(request.Hostname)
+	location := getNullableString(request.Location)
+	userID := getNullableString(request.UserID)
+	return [11]any{ip, request.Path, hostname, request.UserAgent, request.Method, responseTimeMillis(request.ResponseTime), request.Status, location, userID, request.CreatedAt, copyNullable(request.ErrorClass)}
+}
+
 func getPaginatedRequests(c *gin.Context) {
 	var userID string = c.Param("userID")
 	if userID == "" {
@@ -212,8 +251,13 @@ func getPaginatedRequests(c *gin.Context) {
 
 	log.LogToFile(fmt.Sprintf("id=%s: Dashboard page %d access", userID, page))
 
-	connection := database.NewConnection()
-	defer connection.Close(context.Background())
+	conn, err := pool.Acquire(c.Request.Context())
+	if err != nil {
+		databaseUnavailable(c, err)
+		return
+	}
+	defer conn.Release()
+	connection := conn.Conn()
 
 	// Fetch API key corresponding with user ID
 	apiKey, err := getUserAPIKey(connection, userID)
@@ -224,10 +268,10 @@ func getPaginatedRequests(c *gin.Context) {
 	}
 
 	const pageSize int = 400_000
-	requests := [][10]any{}
+	requests := [][11]any{}
 	userAgentIDs := make(map[int]struct{})
 
-	query := "SELECT ip_address, path, hostname, user_agent_id, method, response_time, status, location, user_id, created_at FROM requests WHERE api_key = $1 ORDER BY created_at LIMIT $2 OFFSET $3;"
+	query := "SELECT ip_address, path, hostname, user_agent_id, method, response_time, status, location, user_id, created_at, error_class FROM requests WHERE api_key = $1 ORDER BY created_at LIMIT $2 OFFSET $3;"
 	rows, err := connection.Query(context.Background(), query, apiKey, pageSize, (page-1)*pageSize)
 	if err != nil {
 		log.LogToFile(fmt.Sprintf("key=%s: Invalid API key - %s", apiKey, err.Error()))
@@ -236,19 +280,12 @@ func getPaginatedRequests(c *gin.Context) {
 	}
 	request := new(DashboardRequestRow) // Reuseable request struct
 	for rows.Next() {
-		err := rows.Scan(&request.IPAddress, &request.Path, &request.Hostname, &request.UserAgent, &request.Method, &request.ResponseTime, &request.Status, &request.Location, &request.UserID, &request.CreatedAt)
+		err := rows.Scan(&request.IPAddress, &request.Path, &request.Hostname, &request.UserAgent, &request.Method, &request.ResponseTime, &request.Status, &request.Location, &request.UserID, &request.CreatedAt, &request.ErrorClass)
 		if err != nil {
 			continue
 		}
 
-		var ip string
-		if request.IPAddress.IPNet != nil {
-			ip = request.IPAddress.IPNet.IP.String()
-		}
-		hostname := getNullableString(request.Hostname)
-		location := getNullableString(request.Location)
-		userID := getNullableString(request.UserID)
-		requests = append(requests, [10]any{ip, request.Path, hostname, request.UserAgent, request.Method, request.ResponseTime, request.Status, location, userID, request.CreatedAt})
+		requests = append(requests, newDashboardRequest(request))
 		if request.UserAgent != nil {
 			if _, ok := userAgentIDs[*request.UserAgent]; !ok {
 				userAgentIDs[*request.UserAgent] = struct{}{}
@@ -347,6 +384,22 @@ func getNullableString(value *string) string {
 	return *value
 }
 
+// responseTimeMillis converts a stored response time in microseconds to the
+// milliseconds returned by the API.
+func responseTimeMillis(responseTime int32) float64 {
+	return float64(responseTime) / 1000
+}
+
+// copyNullable returns a copy of a nullable scanned value so that rows do not
+// share a pointer reused between scans.
+func copyNullable[T any](value *T) *T {
+	if value == nil {
+		return nil
+	}
+	v := *value
+	return &v
+}
+
 func compressJSON(data any) ([]byte, error) {
 	// Convert data to []byte
 	body, err := json.Marshal(data)
@@ -379,30 +432,39 @@ func updateLastAccessed(connection *pgx.Conn, apiKey string) error {
 	return err
 }
 
-func buildRequestDataCompact(rows pgx.Rows, cols [10]any) [][10]any {
+func buildRequestDataCompact(rows pgx.Rows, cols [21]any) ([][21]any, *dataCursor) {
 	// First value in list holds column names
-	requests := [][10]any{cols}
-	var request DashboardRequestRow
+	requests := [][21]any{cols}
+	var request RequestRow
+	var last *dataCursor
 	for rows.Next() {
-		err := rows.Scan(&request.IPAddress, &request.Path, &request.Hostname, &request.UserAgent, &request.Method, &request.ResponseTime, &request.Status, &request.Location, &request.UserID, &request.CreatedAt)
+		err := scanRequestRow(rows, &request)
 		if err == nil {
-			requests = append(requests, [10]any{request.IPAddress, request.Path, request.Hostname, request.UserAgent, request.Method, request.ResponseTime, request.Status, request.Location, request.UserID, request.CreatedAt})
+			requests = append(requests, newRequestDataCompact(&request))
+			last = &dataCursor{createdAt: request.CreatedAt, requestID: request.RequestID}
 		}
 	}
-	return requests
+	return requests, last
 }
 
+func newRequestDataCompact(request *RequestRow) [21]any {
+	return [21]any{request.IPAddress, request.Path, request.Hostname, request.UserAgent, request.Method, responseTimeMillis(request.ResponseTime), request.Status, request.Location, request.UserID, request.CreatedAt, copyNullable(request.RequestSize), copyNullable(request.ResponseSize), copyNullable(request.Protocol), request.TLS, request.Tags, copyNullable(request.ErrorClass), copyNullable(request.ErrorMessage), copyNullable(request.Region), copyNullable(request.City), copyNullable(request.ASN), copyNullable(request.ISP)}
+}
+
+// Columns of the compact data format, returned as its first row
+var compactColumns = [21]any{"ip_address", "path", "hostname", "user_agent", "method", "response_time", "status", "location", "user_id", "created_at", "request_size", "response_size", "protocol", "tls", "tags", "error_class", "error_message", "region", "city", "asn", "isp"}
+
 type DataFetchQueries struct {
-	page      int
-	compact   bool
-	date      time.Time
-	dateFrom  time.Time
-	dateTo    time.Time
-	hostname  string
-	ipAddress string
-	location  string
-	status    int
-	userID    string
+	page     int
+	compact  bool
+	date     time.Time
+	dateFrom time.Time
+	dateTo   time.Time
+	filters  []dataFilter
+	tags     map[string]string // Only rows with all of these tags are returned
+	groupBy  string            // Tag key to count requests by instead of returning rows
+	cursor   *dataCursor       // Rows after this position are returned instead of a page
+	format   string            // Export format all matching rows are streamed in, empty for pages of JSON
 }
 
 func getData(c *gin.Context) {
@@ -417,31 +479,79 @@ func getData(c *gin.Context) {
 		}
 	}
 
-	log.LogToFile(fmt.Sprintf("key=%s: Data access", apiKey))
-
 	// Get any queries from url
-	queries := getQueriesFromRequest(c)
+	queries, err := getQueriesFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+	cursor, err := parseDataCursor(c.Query("cursor"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Invalid cursor."})
+		return
+	}
+	queries.cursor = cursor
+	format, err := getExportFormat(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Unsupported format."})
+		return
+	}
+	queries.format = format
 
-	connection := database.NewConnection()
-	defer connection.Close(context.Background())
+	conn, err := pool.Acquire(c.Request.Context())
+	if err != nil {
+		databaseUnavailable(c, err)
+		return
+	}
+	defer conn.Release()
+	connection := conn.Conn()
+
+	apiKey, ok := authenticate(c, connection, apiKey, database.ScopeRead)
+	if !ok {
+		return
+	}
+
+	log.LogToFile(fmt.Sprintf("key=%s: Data access", apiKey))
 
 	// Fetch all API request data associated with this account
-	query, arguments := buildDataFetchQuery(apiKey, queries)
-	rows, err := connection.Query(context.Background(), query, arguments...)
+	var query string
+	var arguments []any
+	if queries.groupBy != "" {
+		query, arguments = buildTagGroupQuery(apiKey, queries)
+	} else {
+		query, arguments = buildDataFetchQuery(apiKey, queries)
+	}
+	rows, err := connection.Query(c.Request.Context(), query, arguments...)
 	if err != nil {
+		if database.IsUnavailable(err) {
+			databaseUnavailable(c, err)
+			return
+		}
 		log.LogToFile(fmt.Sprintf("key=%s: Queries failed - %s", apiKey, err.Error()))
 		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Invalid API key."})
 		return
 	}
 
 	// Read data into list of objects to return
-	if queries.compact {
-		cols := [10]any{"ip_address", "path", "hostname", "user_agent", "method", "response_time", "status", "location", "user_id", "created_at"}
-		requests := buildRequestDataCompact(rows, cols)
+	if queries.groupBy != "" {
+		groups := buildTagGroups(rows)
+		log.LogToFile(fmt.Sprintf("key=%s: Data access successful (%d groups)", apiKey, len(groups)))
+		c.JSON(http.StatusOK, groups)
+	} else if queries.format != "" {
+		count, err := exportRequestData(c, rows, queries.format)
+		if err != nil {
+			log.LogToFile(fmt.Sprintf("key=%s: Data export failed after %d rows - %s", apiKey, count, err.Error()))
+		} else {
+			log.LogToFile(fmt.Sprintf("key=%s: Data export successful (%s, %d)", apiKey, queries.format, count))
+		}
+	} else if queries.compact {
+		requests, last := buildRequestDataCompact(rows, compactColumns)
+		setNextCursor(c, last, len(requests)-1)
 		log.LogToFile(fmt.Sprintf("key=%s: Data access successful (%d)", apiKey, len(requests)-1))
 		c.JSON(http.StatusOK, requests)
 	} else {
-		requests := buildRequestData(rows)
+		requests, last := buildRequestData(rows)
+		setNextCursor(c, last, len(requests))
 		log.LogToFile(fmt.Sprintf("key=%s: Data access successful (%d)", apiKey, len(requests)))
 		c.JSON(http.StatusOK, requests)
 	}
@@ -458,74 +568,123 @@ func getData(c *gin.Context) {
 
 func buildDataFetchQuery(apiKey string, queries DataFetchQueries) (string, []any) {
 	var query strings.Builder
-	query.WriteString("SELECT r.ip_address, r.path, r.hostname, u.user_agent, r.method, r.response_time, r.status, r.location, r.user_id, r.created_at FROM requests r JOIN user_agents u ON r.user_agent_id = u.id WHERE api_key = $1")
+	query.WriteString("SELECT r.request_id, r.ip_address, r.path, r.hostname, u.user_agent, r.method, r.response_time, r.status, r.location, r.user_id, r.created_at, r.request_size, r.response_size, r.protocol, r.tls, r.tags, r.error_class, r.error_message, r.region, r.city, r.asn, r.isp FROM requests r JOIN user_agents u ON r.user_agent_id = u.id WHERE api_key = $1")
 
 	arguments := []any{apiKey}
+	arguments = writeDataFilters(&query, arguments, queries)
+
+	// Ordered by request ID as well so that rows sharing a timestamp are
+	// never skipped or repeated between pages
+	if queries.cursor != nil {
+		query.WriteString(fmt.Sprintf(" and (r.created_at, r.request_id) > ($%d, $%d)", len(arguments)+1, len(arguments)+2))
+		arguments = append(arguments, queries.cursor.createdAt, queries.cursor.requestID)
+	}
+	query.WriteString(" ORDER BY r.created_at, r.request_id")
 
+	if queries.format != "" {
+		query.WriteString(";")
+	} else if queries.cursor != nil {
+		query.WriteString(fmt.Sprintf(" LIMIT %d;", dataPageSize))
+	} else {
+		// Deprecated page numbers, slower for each page further through the data
+		offset := (queries.page - 1) * dataPageSize
+		query.WriteString(fmt.Sprintf(" LIMIT %d OFFSET %d;", dataPageSize, offset))
+	}
+	return query.String(), arguments
+}
+
+// buildTagGroupQuery counts the requests matching the filters for each value
+// of the tag key being grouped by. Sampled rows count as the number of
+// requests they represent.
+func buildTagGroupQuery(apiKey string, queries DataFetchQueries) (string, []any) {
+	var query strings.Builder
+	query.WriteString("SELECT r.tags->>$2 AS value, count(*), sum(r.weight) FROM requests r WHERE api_key = $1")
+
+	arguments := []any{apiKey, queries.groupBy}
+	arguments = writeDataFilters(&query, arguments, queries)
+
+	query.WriteString(" GROUP BY value ORDER BY 3 DESC;")
+	return query.String(), arguments
+}
+
+// writeDataFilters appends the conditions for the filters in the request
+// queries, returning the arguments extended with their values.
+func writeDataFilters(query *strings.Builder, arguments []any, queries DataFetchQueries) []any {
 	// Providing a single date takes priority over range with dateFrom and dateTo
 	if !queries.date.IsZero() && database.ValidDate(queries.date) {
-		query.WriteString(fmt.Sprintf(" and r.created_at >= $%d and r.created_at < date $%d + interval '1 days'", len(arguments)+1, len(arguments)+2))
-		arguments = append(arguments, queries.date.Format("2006-01-02"), queries.date.Format("2006-01-02"))
+		query.WriteString(fmt.Sprintf(" and r.created_at >= $%d and r.created_at < $%d", len(arguments)+1, len(arguments)+2))
+		arguments = append(arguments, queries.date, queries.date.AddDate(0, 0, 1))
 	} else {
 		if !queries.dateFrom.IsZero() && database.ValidDate(queries.dateFrom) {
 			query.WriteString(fmt.Sprintf(" and r.created_at >= $%d", len(arguments)+1))
-			arguments = append(arguments, queries.dateFrom.Format("2006-01-02"))
+			arguments = append(arguments, queries.dateFrom)
 		}
 		if !queries.dateTo.IsZero() && database.ValidDate(queries.dateTo) {
 			query.WriteString(fmt.Sprintf(" and r.created_at <= $%d", len(arguments)+1))
-			arguments = append(arguments, queries.dateTo.Format("2006-01-02"))
+			arguments = append(arguments, queries.dateTo)
 		}
 	}
 
-	if queries.ipAddress != "" && database.ValidIPAddress(queries.ipAddress) {
-		query.WriteString(fmt.Sprintf(" and r.ip_address = $%d", len(arguments)+1))
-		arguments = append(arguments, queries.ipAddress)
+	for _, filter := range queries.filters {
+		arguments = filter.write(query, arguments)
 	}
 
-	if queries.location != "" && database.ValidLocation(queries.location) {
-		query.WriteString(fmt.Sprintf(" and r.location = $%d", len(arguments)+1))
-		arguments = append(arguments, queries.location)
+	if len(queries.tags) > 0 {
+		// Containment check is supported by the index on tags
+		tags, err := json.Marshal(queries.tags)
+		if err == nil {
+			query.WriteString(fmt.Sprintf(" and r.tags @> $%d", len(arguments)+1))
+			arguments = append(arguments, string(tags))
+		}
 	}
 
-	if queries.status != 0 && database.ValidStatus(queries.status) {
-		query.WriteString(fmt.Sprintf(" and r.status = $%d", len(arguments)+1))
-		arguments = append(arguments, queries.status)
-	}
+	return arguments
+}
 
-	if queries.hostname != "" && database.ValidString(queries.hostname) {
-		query.WriteString(fmt.Sprintf(" and r.hostname = $%d", len(arguments)+1))
-		arguments = append(arguments, queries.hostname)
-	}
+type TagGroup struct {
+	Value    *string `json:"value"` // Null for requests without the tag
+	Rows     int64   `json:"rows"`
+	Requests float64 `json:"requests"` // Estimated total including sampled out requests
+}
 
-	if queries.userID != "" && database.ValidString(queries.userID) {
-		query.WriteString(fmt.Sprintf(" and r.user_id = $%d", len(arguments)+1))
-		arguments = append(arguments, queries.userID)
+func buildTagGroups(rows pgx.Rows) []TagGroup {
+	groups := make([]TagGroup, 0)
+	for rows.Next() {
+		var group TagGroup
+		err := rows.Scan(&group.Value, &group.Rows, &group.Requests)
+		if err == nil {
+			groups = append(groups, group)
+		}
 	}
-
-	const pageSize = 50_000
-	offset := (queries.page - 1) * pageSize
-	query.WriteString(fmt.Sprintf(" ORDER BY created_at LIMIT %d OFFSET %d;", pageSize, offset))
-	return query.String(), arguments
+	return groups
 }
 
-func getQueriesFromRequest(c *gin.Context) DataFetchQueries {
+// getQueriesFromRequest reads the filters and options for data access from
+// the request, returning an error describing the first invalid filter.
+func getQueriesFromRequest(c *gin.Context) (DataFetchQueries, error) {
 	pageQuery := c.Query("page")
 	compactQuery := c.Query("compact")
 	dateQuery := c.Query("date")
 	dateFromQuery := c.Query("dateFrom")
 	dateToQuery := c.Query("dateTo")
-	hostname := c.Query("hostname")
-	ipAddressQuery := c.Query("ip")
-	locationQuery := c.Query("location")
-	statusQuery := c.Query("status")
-	userIDQuery := c.Query("userID")
+	tagsQuery := c.QueryMap("tag")
+	groupByQuery := c.Query("groupBy")
 
 	date := parseQueryDate(dateQuery)
-	dateFrom := parseQueryDate(dateFromQuery)
-	dateTo := parseQueryDate(dateToQuery)
-	status, err := strconv.Atoi(statusQuery)
+	if dateQuery != "" && date.IsZero() {
+		return DataFetchQueries{}, errors.New("Invalid date.")
+	}
+	dateFrom := parseQueryDateTime(dateFromQuery)
+	if dateFromQuery != "" && dateFrom.IsZero() {
+		return DataFetchQueries{}, errors.New("Invalid start date.")
+	}
+	dateTo := parseQueryDateTime(dateToQuery)
+	if dateToQuery != "" && dateTo.IsZero() {
+		return DataFetchQueries{}, errors.New("Invalid end date.")
+	}
+	filters, err := getDataFilters(c)
 	if err != nil {
-		status = 0
+		return DataFetchQueries{}, err
 	}
 	page := 1
 	if pageQuery != "" {
@@ -539,15 +698,30 @@ func getQueriesFromRequest(c *gin.Context) DataFetchQueries {
 		page,
 		compactQuery == "true",
 		date,
-		dateFrom,
-		dateTo,
-		hostname,
-		ipAddressQuery,
-		locationQuery,
-		status,
-		userIDQuery,
+		dateFrom.UTC(),
+		dateTo.UTC(),
+		filters,
+		getValidTags(tagsQuery),
+		"",
+		nil,
+		"",
+	}
+	if database.ValidTagKey(groupByQuery) {
+		queries.groupBy = groupByQuery
+	}
+	return queries, nil
+}
+
+// getValidTags returns the tag filters with valid keys and values, ignoring
+// any others.
+func getValidTags(tags map[string]string) map[string]string {
+	valid := make(map[string]string)
+	for key, value := range tags {
+		if database.ValidTagKey(key) && database.ValidTagValue(value) {
+			valid[key] = value
+		}
 	}
-	return queries
+	return valid
 }
 
 func parseQueryDate(date string) time.Time {
@@ -568,6 +742,9 @@ func parseQueryDateTime(date string) time.Time {
 	}
 
 	// Try parse date time
+	if d, err := time.Parse(time.RFC3339, date); err == nil {
+		return d
+	}
 	if d, err := time.Parse("2006-01-02 15:04:05", date); err == nil {
 		return d
 	}
@@ -580,60 +757,102 @@ func parseQueryDateTime(date string) time.Time {
 }
 
 type RequestData struct {
-	Hostname     string    `json:"hostname"`
-	IPAddress    string    `json:"ip_address"`
-	Path         string    `json:"path"`
-	UserAgent    string    `json:"user_agent"`
-	Method       int16     `json:"method"`
-	Status       int16     `json:"status"`
-	ResponseTime int16     `json:"response_time"`
-	Location     string    `json:"location"`
-	UserID       string    `json:"user_id"`
-	CreatedAt    time.Time `json:"created_at"`
+	Hostname     string            `json:"hostname"`
+	IPAddress    string            `json:"ip_address"`
+	Path         string            `json:"path"`
+	UserAgent    string            `json:"user_agent"`
+	Method       int16             `json:"method"`
+	Status       int16             `json:"status"`
+	ResponseTime float64           `json:"response_time"` // Milliseconds
+	Location     string            `json:"location"`
+	UserID       string            `json:"user_id"`
+	CreatedAt    time.Time         `json:"created_at"`
+	RequestSize  *int64            `json:"request_size"`  // Nullable, unknown for older rows
+	ResponseSize *int64            `json:"response_size"` // Nullable
+	Protocol     *int16            `json:"protocol"`      // Nullable
+	TLS          bool              `json:"tls"`
+	Tags         map[string]string `json:"tags"`
+	ErrorClass   *int16            `json:"error_class"`   // Nullable, set if the request failed
+	ErrorMessage *string           `json:"error_message"` // Nullable, only stored if captured by the client
+	Region       *string           `json:"region"`        // Nullable, only inferred if the city database is configured
+	City         *string           `json:"city"`          // Nullable
+	ASN          *int64            `json:"asn"`           // Nullable, only inferred if the ASN database is configured
+	ISP          *string           `json:"isp"`           // Nullable
 }
 
 type RequestRow struct {
-	Hostname     *string     `json:"hostname"`
-	IPAddress    pgtype.CIDR `json:"ip_address"`
-	Path         string      `json:"path"`
-	UserAgent    *string     `json:"user_agent"`
-	Method       int16       `json:"method"`
-	Status       int16       `json:"status"`
-	ResponseTime int16       `json:"response_time"`
-	Location     *string     `json:"location"`
-	UserID       *string     `json:"user_id"` // Custom user identifier field specific to each API service
-	CreatedAt    time.Time   `json:"created_at"`
+	RequestID    int64             `json:"-"`
+	Hostname     *string           `json:"hostname"`
+	IPAddress    pgtype.CIDR       `json:"ip_address"`
+	Path         string            `json:"path"`
+	UserAgent    *string           `json:"user_agent"`
+	Method       int16             `json:"method"`
+	Status       int16             `json:"status"`
+	ResponseTime int32             `json:"response_time"` // Microseconds
+	Location     *string           `json:"location"`
+	UserID       *string           `json:"user_id"` // Custom user identifier field specific to each API service
+	CreatedAt    time.Time         `json:"created_at"`
+	RequestSize  *int64            `json:"request_size"`
+	ResponseSize *int64            `json:"response_size"`
+	Protocol     *int16            `json:"protocol"`
+	TLS          bool              `json:"tls"`
+	Tags         map[string]string `json:"tags"`
+	ErrorClass   *int16            `json:"error_class"`   // Nullable, set if the request failed
+	ErrorMessage *string           `json:"error_message"` // Nullable, only stored if captured by the client
+	Region       *string           `json:"region"`        // Nullable, only inferred if the city database is configured
+	City         *string           `json:"city"`          // Nullable
+	ASN          *int64            `json:"asn"`           // Nullable, only inferred if the ASN database is configured
+	ISP          *string           `json:"isp"`           // Nullable
+}
+
+func scanRequestRow(rows pgx.Rows, request *RequestRow) error {
+	// Reset as JSON is decoded into any existing map
+	request.Tags = nil
+	return rows.Scan(&request.RequestID, &request.IPAddress, &request.Path, &request.Hostname, &request.UserAgent, &request.Method, &request.ResponseTime, &request.Status, &request.Location, &request.UserID, &request.CreatedAt, &request.RequestSize, &request.ResponseSize, &request.Protocol, &request.TLS, &request.Tags, &request.ErrorClass, &request.ErrorMessage, &request.Region, &request.City, &request.ASN, &request.ISP)
 }
 
-func buildRequestData(rows pgx.Rows) []RequestData {
+func buildRequestData(rows pgx.Rows) ([]RequestData, *dataCursor) {
 	requests := make([]RequestData, 0)
 	var request RequestRow
+	var last *dataCursor
 	for rows.Next() {
-		err := rows.Scan(&request.IPAddress, &request.Path, &request.Hostname, &request.UserAgent, &request.Method, &request.ResponseTime, &request.Status, &request.Location, &request.UserID, &request.CreatedAt)
+		err := scanRequestRow(rows, &request)
 		if err == nil {
-			var ip string
-			if request.IPAddress.IPNet != nil {
-				ip = request.IPAddress.IPNet.IP.String()
-			}
-			hostname := getNullableString(request.Hostname)
-			userAgent := getNullableString(request.UserAgent)
-			location := getNullableString(request.Location)
-			userID := getNullableString(request.UserID)
-			requests = append(requests, RequestData{
-				IPAddress:    ip,
-				Path:         request.Path,
-				Hostname:     hostname,
-				UserAgent:    userAgent,
-				Method:       request.Method,
-				Status:       request.Status,
-				ResponseTime: request.ResponseTime,
-				Location:     location,
-				UserID:       userID,
-				CreatedAt:    request.CreatedAt,
-			})
+			requests = append(requests, newRequestData(&request))
+			last = &dataCursor{createdAt: request.CreatedAt, requestID: request.RequestID}
 		}
 	}
-	return requests
+	return requests, last
+}
+
+func newRequestData(request *RequestRow) RequestData {
+	var ip string
+	if request.IPAddress.IPNet != nil {
+		ip = request.IPAddress.IPNet.IP.String()
+	}
+	return RequestData{
+		IPAddress:    ip,
+		Path:         request.Path,
+		Hostname:     getNullableString(request.Hostname),
+		UserAgent:    getNullableString(request.UserAgent),
+		Method:       request.Method,
+		Status:       request.Status,
+		ResponseTime: responseTimeMillis(request.ResponseTime),
+		Location:     getNullableString(request.Location),
+		UserID:       getNullableString(request.UserID),
+		CreatedAt:    request.CreatedAt,
+		RequestSize:  copyNullable(request.RequestSize),
+		ResponseSize: copyNullable(request.ResponseSize),
+		Protocol:     copyNullable(request.Protocol),
+		TLS:          request.TLS,
+		Tags:         request.Tags,
+		ErrorClass:   copyNullable(request.ErrorClass),
+		ErrorMessage: copyNullable(request.ErrorMessage),
+		Region:       copyNullable(request.Region),
+		City:         copyNullable(request.City),
+		ASN:          copyNullable(request.ASN),
+		ISP:          copyNullable(request.ISP),
+	}
 }
 
 func deleteUserRequests(apiKey string, c *gin.Context, connection *pgx.Conn) error {
@@ -685,10 +904,21 @@ func deleteData(c *gin.Context) {
 
 	if apiKey == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Invalid API key."})
+		return
 	}
 
-	connection := database.NewConnection()
-	defer connection.Close(context.Background())
+	conn, err := pool.Acquire(c.Request.Context())
+	if err != nil {
+		databaseUnavailable(c, err)
+		return
+	}
+	defer conn.Release()
+	connection := conn.Conn()
+
+	apiKey, ok := authenticate(c, connection, apiKey, database.ScopeDelete)
+	if !ok {
+		return
+	}
 
 	if err := deleteUserRequests(apiKey, c, connection); err != nil {
 		return
@@ -698,6 +928,8 @@ func deleteData(c *gin.Context) {
 		return
 	} else if err := deleteUserPings(apiKey, c, connection); err != nil {
 		return
+	} else if err := deleteUserTokens(apiKey, c, connection); err != nil {
+		return
 	}
 
 	// Return API request data
@@ -719,8 +951,13 @@ func getUserMonitor(c *gin.Context) {
 		return
 	}
 
-	connection := database.NewConnection()
-	defer connection.Close(context.Background())
+	conn, err := pool.Acquire(c.Request.Context())
+	if err != nil {
+		databaseUnavailable(c, err)
+		return
+	}
+	defer conn.Release()
+	connection := conn.Conn()
 
 	// Retreive monitors created by this user
 	query := "SELECT url, secure, ping, monitor.created_at FROM monitor INNER JOIN users ON users.api_key = monitor.api_key WHERE users.user_id = $1;"
@@ -761,7 +998,7 @@ func addUserMonitor(c *gin.Context) {
 		return
 	}
 
-	if monitor.UserID == "" {
+	if monitor.UserID == "" && c.GetHeader("X-AUTH-TOKEN") == "" {
 		log.LogToFile("User ID empty")
 		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "User ID required."})
 		return
@@ -769,22 +1006,22 @@ func addUserMonitor(c *gin.Context) {
 
 	log.LogToFile(fmt.Sprintf("id=%s: Add monitor", monitor.UserID))
 
-	connection := database.NewConnection()
-	defer connection.Close(context.Background())
-
-	// Get API key from user ID
-	var apiKey string
-	query := "SELECT api_key FROM users WHERE user_id = $1;"
-	err = connection.QueryRow(context.Background(), query, monitor.UserID).Scan(&apiKey)
+	conn, err := pool.Acquire(c.Request.Context())
 	if err != nil {
-		log.LogToFile(fmt.Sprintf("id=%s: Invalid monitor user ID - %s", monitor.UserID, err.Error()))
-		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Invalid data."})
+		databaseUnavailable(c, err)
+		return
+	}
+	defer conn.Release()
+	connection := conn.Conn()
+
+	apiKey, ok := getMonitorAPIKey(c, connection, monitor.UserID)
+	if !ok {
 		return
 	}
 
 	// Check if monitor already exists
 	var count int
-	query = "SELECT count(*) FROM monitor WHERE api_key = $1 AND url = $2;"
+	query := "SELECT count(*) FROM monitor WHERE api_key = $1 AND url = $2;"
 	err = connection.QueryRow(context.Background(), query, apiKey, monitor.URL).Scan(&count)
 	if err != nil {
 		log.LogToFile(fmt.Sprintf("key=%s: Failed to get monitor count - %s", apiKey, err.Error()))
@@ -828,6 +1065,39 @@ func addUserMonitor(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{"status": http.StatusCreated, "message": "New monitor created successfully."})
 }
 
+// getMonitorAPIKey returns the API key of the account whose monitors are
+// managed, from a credential with the monitor-admin scope in the X-AUTH-TOKEN
+// header or otherwise from the user ID, writing an error response if invalid.
+func getMonitorAPIKey(c *gin.Context, connection *pgx.Conn, userID string) (string, bool) {
+	if credential := c.GetHeader("X-AUTH-TOKEN"); credential != "" {
+		apiKey, ok := authenticate(c, connection, credential, database.ScopeMonitorAdmin)
+		if !ok {
+			return "", false
+		}
+
+		// Check the account exists
+		var count int
+		query := "SELECT count(*) FROM users WHERE api_key = $1;"
+		err := connection.QueryRow(context.Background(), query, apiKey).Scan(&count)
+		if err != nil || count == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Invalid API key."})
+			return "", false
+		}
+		return apiKey, true
+	}
+
+	// Get API key from user ID
+	var apiKey string
+	query := "SELECT api_key FROM users WHERE user_id = $1;"
+	err := connection.QueryRow(context.Background(), query, userID).Scan(&apiKey)
+	if err != nil {
+		log.LogToFile(fmt.Sprintf("id=%s: Invalid monitor user ID - %s", userID, err.Error()))
+		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Invalid data."})
+		return "", false
+	}
+	return apiKey, true
+}
+
 func deleteMonitor(apiKey string, url string, c *gin.Context, connection *pgx.Conn) error {
 	// Delete user's monitor to this specific url
 	query := "DELETE FROM monitor WHERE api_key = $1 AND url = $2;"
@@ -862,7 +1132,7 @@ func deleteUserMonitor(c *gin.Context) {
 		return
 	}
 
-	if body.UserID == "" {
+	if body.UserID == "" && c.GetHeader("X-AUTH-TOKEN") == "" {
 		log.LogToFile("User ID empty")
 		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "User ID required."})
 		return
@@ -870,16 +1140,16 @@ func deleteUserMonitor(c *gin.Context) {
 
 	log.LogToFile(fmt.Sprintf("id=%s: Delete monitor", body.UserID))
 
-	connection := database.NewConnection()
-	defer connection.Close(context.Background())
-
-	// Get API key from user ID
-	var apiKey string
-	query := "SELECT api_key FROM users WHERE user_id = $1;"
-	err = connection.QueryRow(context.Background(), query, body.UserID).Scan(&apiKey)
+	conn, err := pool.Acquire(c.Request.Context())
 	if err != nil {
-		log.LogToFile(fmt.Sprintf("id=%s: Invalid monitor user ID - %s", body.UserID, err.Error()))
-		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Invalid data."})
+		databaseUnavailable(c, err)
+		return
+	}
+	defer conn.Release()
+	connection := conn.Conn()
+
+	apiKey, ok := getMonitorAPIKey(c, connection, body.UserID)
+	if !ok {
 		return
 	}
 
@@ -927,8 +1197,13 @@ func getUserPings(c *gin.Context) {
 
 	log.LogToFile(fmt.Sprintf("id=%s: Monitor access", userID))
 
-	connection := database.NewConnection()
-	defer connection.Close(context.Background())
+	conn, err := pool.Acquire(c.Request.Context())
+	if err != nil {
+		databaseUnavailable(c, err)
+		return
+	}
+	defer conn.Release()
+	connection := conn.Conn()
 
 	// Fetch user ID corresponding with API key
 	query := "SELECT url FROM monitor INNER JOIN users ON users.api_key = monitor.api_key WHERE users.user_id = $1;"
@@ -983,7 +1258,27 @@ func getUserPings(c *gin.Context) {
 	c.JSON(http.StatusOK, monitors)
 }
 
-func RegisterRouter(r *gin.RouterGroup) {
+// pool is the database connection pool shared by the route handlers.
+var pool *database.Pool
+
+// databaseUnavailable responds when a connection cannot be acquired from the
+// pool because the database is unreachable.
+func databaseUnavailable(c *gin.Context, err error) {
+	log.LogToFile(fmt.Sprintf("Database unavailable - %s", err.Error()))
+	c.JSON(http.StatusServiceUnavailable, gin.H{"status": http.StatusServiceUnavailable, "message": "Service temporarily unavailable."})
+}
+
+func getHealth(c *gin.Context) {
+	if err := pool.Healthy(c.Request.Context()); err != nil {
+		databaseUnavailable(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "message": "OK"})
+}
+
+func RegisterRouter(r *gin.RouterGroup, p *database.Pool) {
+	pool = p
+	r.GET("/health", getHealth)
 	r.GET("/generate-api-key", genAPIKey)
 	r.GET("/user-id/:apiKey", getUserID)
 	r.GET("/requests/:userID", getRequests)
@@ -992,5 +1287,10 @@ func RegisterRouter(r *gin.RouterGroup) {
 	r.GET("/monitor/pings/:userID", getUserPings)
 	r.POST("/monitor/add", addUserMonitor)
 	r.POST("/monitor/delete", deleteUserMonitor)
+	r.GET("/tokens", getTokens)
+	r.POST("/tokens/create", createToken)
+	r.POST("/tokens/revoke", revokeToken)
 	r.GET("/data", getData)
+	r.GET("/aggregate/:userID/series", getAggregateSeries)
+	r.GET("/aggregate/:userID/top/:dimension", getAggregateTop)
 }
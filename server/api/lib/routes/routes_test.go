@@ -0,0 +1,67 @@
+package routes
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadRequestPages(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	userAgent := 3
+	var rows []DashboardRequestRow
+	for i := 0; i < 7; i++ {
+		// Pairs of rows share a timestamp so pages continue by request ID
+		rows = append(rows, DashboardRequestRow{RequestID: int64(i + 1), Path: "/", UserAgent: &userAgent, CreatedAt: start.Add(time.Duration(i/2) * time.Second)})
+	}
+
+	var pages int
+	fetchPage := func(after dataCursor, limit int, read func(*DashboardRequestRow)) error {
+		pages++
+		var count int
+		for i := range rows {
+			row := rows[i]
+			if row.CreatedAt.Before(after.createdAt) || (row.CreatedAt.Equal(after.createdAt) && row.RequestID <= after.requestID) {
+				continue
+			}
+			if count == limit {
+				break
+			}
+			read(&row)
+			count++
+		}
+		return nil
+	}
+
+	tests := []struct {
+		pageSize    int
+		maxRequests int
+		expected    int
+		pages       int
+	}{
+		{2, 100, 7, 4},
+		{3, 100, 7, 3},
+		{7, 100, 7, 2}, // Full final page requires another to find the end
+		{2, 5, 5, 3},
+		{100, 5, 5, 1},
+	}
+
+	for i, test := range tests {
+		pages = 0
+		requests, userAgentIDs, err := readRequestPages(fetchPage, test.pageSize, test.maxRequests)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(requests) != test.expected || pages != test.pages {
+			t.Errorf("%d: got %d requests in %d pages, expected %d in %d", i, len(requests), pages, test.expected, test.pages)
+			continue
+		}
+		for j, request := range requests {
+			if createdAt := request[9].(time.Time); !createdAt.Equal(rows[j].CreatedAt) {
+				t.Errorf("%d: got request %d created at %v, expected %v", i, j, createdAt, rows[j].CreatedAt)
+			}
+		}
+		if _, ok := userAgentIDs[userAgent]; !ok || len(userAgentIDs) != 1 {
+			t.Errorf("%d: got user agents %v, expected only %d", i, userAgentIDs, userAgent)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/tom-draper/api-analytics/server/api/lib/log"
+	"github.com/tom-draper/api-analytics/server/database"
+)
+
+// Maximum number of unrevoked tokens an account can hold
+const maxTokens int = 25
+
+// authenticate returns the API key of the account a credential belongs to if
+// it grants the scope, writing an error response if not. API keys grant
+// every scope; tokens only those they were created with.
+func authenticate(c *gin.Context, connection *pgx.Conn, credential string, scope string) (string, bool) {
+	if !database.IsToken(credential) {
+		return credential, true
+	}
+
+	apiKey, err := database.ResolveToken(c.Request.Context(), connection, credential, scope)
+	if err == nil {
+		return apiKey, true
+	}
+
+	if errors.Is(err, database.ErrTokenScope) {
+		log.LogToFile(fmt.Sprintf("Token without %s scope used", scope))
+		c.JSON(http.StatusForbidden, gin.H{"status": http.StatusForbidden, "message": fmt.Sprintf("Token does not have the %s scope.", scope)})
+	} else if database.IsUnavailable(err) {
+		databaseUnavailable(c, err)
+	} else {
+		if !errors.Is(err, database.ErrInvalidToken) {
+			log.LogToFile(fmt.Sprintf("Token lookup failed - %s", err.Error()))
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"status": http.StatusUnauthorized, "message": "Invalid token."})
+	}
+	return "", false
+}
+
+// getAccountAPIKey returns the API key in the X-AUTH-TOKEN header, used to
+// manage tokens, writing an error response if it does not belong to an
+// account. Tokens cannot be used to manage other tokens.
+func getAccountAPIKey(c *gin.Context, connection *pgx.Conn) (string, bool) {
+	apiKey := c.GetHeader("X-AUTH-TOKEN")
+	if apiKey == "" || database.IsToken(apiKey) {
+		c.JSON(http.StatusForbidden, gin.H{"status": http.StatusForbidden, "message": "Tokens can only be managed with an API key."})
+		return "", false
+	}
+
+	var count int
+	query := "SELECT count(*) FROM users WHERE api_key = $1;"
+	err := connection.QueryRow(context.Background(), query, apiKey).Scan(&count)
+	if err != nil && database.IsUnavailable(err) {
+		databaseUnavailable(c, err)
+		return "", false
+	} else if err != nil || count == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Invalid API key."})
+		return "", false
+	}
+	return apiKey, true
+}
+
+type Token struct {
+	TokenID    string     `json:"token_id"`
+	Token      string     `json:"token,omitempty"` // Only returned when created
+	Label      string     `json:"label"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+type TokenRequest struct {
+	Label     string     `json:"label"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// validateTokenRequest checks the scopes, label and expiry of a token to be
+// created, removing any repeated scopes.
+func validateTokenRequest(request *TokenRequest) error {
+	if len(request.Scopes) == 0 {
+		return errors.New("At least one scope required.")
+	}
+	scopes := make([]string, 0, len(request.Scopes))
+	for _, scope := range request.Scopes {
+		if !database.ValidScope(scope) {
+			return fmt.Errorf("Invalid scope %q.", scope)
+		}
+		if !contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	request.Scopes = scopes
+
+	if !database.ValidString(request.Label) {
+		return errors.New("Invalid label.")
+	}
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		return errors.New("Expiry must be in the future.")
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func createToken(c *gin.Context) {
+	var request TokenRequest
+	err := c.BindJSON(&request)
+	if err != nil {
+		log.LogToFile("Invalid token to create")
+		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Invalid request body."})
+		return
+	}
+	if err := validateTokenRequest(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+
+	conn, err := pool.Acquire(c.Request.Context())
+	if err != nil {
+		databaseUnavailable(c, err)
+		return
+	}
+	defer conn.Release()
+	connection := conn.Conn()
+
+	apiKey, ok := getAccountAPIKey(c, connection)
+	if !ok {
+		return
+	}
+
+	// Check if existing tokens already at max limit
+	var tokenCount int
+	query := "SELECT count(*) FROM tokens WHERE api_key = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW());"
+	err = connection.QueryRow(context.Background(), query, apiKey).Scan(&tokenCount)
+	if err != nil {
+		log.LogToFile(fmt.Sprintf("key=%s: Failed to get token count - %s", apiKey, err.Error()))
+		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Invalid data."})
+		return
+	}
+	if tokenCount >= maxTokens {
+		log.LogToFile(fmt.Sprintf("key=%s: Token limit reached (%d)", apiKey, tokenCount))
+		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Token limit reached."})
+		return
+	}
+
+	token := Token{Label: request.Label, Scopes: request.Scopes, ExpiresAt: request.ExpiresAt}
+	token.Token, err = database.NewToken()
+	if err != nil {
+		log.LogToFile(fmt.Sprintf("key=%s: Token generation failed - %s", apiKey, err.Error()))
+		c.JSON(http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": "Token generation failed."})
+		return
+	}
+
+	// Insert new token into database
+	query = "INSERT INTO tokens (token_hash, api_key, label, scopes, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING token_id, created_at;"
+	err = connection.QueryRow(context.Background(), query, database.HashToken(token.Token), apiKey, token.Label, token.Scopes, token.ExpiresAt).Scan(&token.TokenID, &token.CreatedAt)
+	if err != nil {
+		log.LogToFile(fmt.Sprintf("key=%s: Failed to create new token - %s", apiKey, err.Error()))
+		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Invalid data."})
+		return
+	}
+
+	log.LogToFile(fmt.Sprintf("key=%s: Token '%s' created successfully", apiKey, token.TokenID))
+
+	// Return token, which cannot be retrieved again
+	c.JSON(http.StatusCreated, token)
+}
+
+func getTokens(c *gin.Context) {
+	conn, err := pool.Acquire(c.Request.Context())
+	if err != nil {
+		databaseUnavailable(c, err)
+		return
+	}
+	defer conn.Release()
+	connection := conn.Conn()
+
+	apiKey, ok := getAccountAPIKey(c, connection)
+	if !ok {
+		return
+	}
+
+	query := "SELECT token_id, label, scopes, created_at, expires_at, last_used_at, revoked_at FROM tokens WHERE api_key = $1 ORDER BY created_at;"
+	rows, err := connection.Query(context.Background(), query, apiKey)
+	if err != nil {
+		log.LogToFile(fmt.Sprintf("key=%s: Failed to get tokens - %s", apiKey, err.Error()))
+		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Invalid API key."})
+		return
+	}
+	defer rows.Close()
+
+	// Read tokens into list to return
+	tokens := make([]Token, 0)
+	for rows.Next() {
+		var token Token
+		err := rows.Scan(&token.TokenID, &token.Label, &token.Scopes, &token.CreatedAt, &token.ExpiresAt, &token.LastUsedAt, &token.RevokedAt)
+		if err == nil {
+			tokens = append(tokens, token)
+		}
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func revokeToken(c *gin.Context) {
+	var body struct {
+		TokenID string `json:"token_id"`
+	}
+	err := c.BindJSON(&body)
+	if err != nil || body.TokenID == "" {
+		log.LogToFile("Invalid token to revoke")
+		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Invalid request body."})
+		return
+	}
+
+	conn, err := pool.Acquire(c.Request.Context())
+	if err != nil {
+		databaseUnavailable(c, err)
+		return
+	}
+	defer conn.Release()
+	connection := conn.Conn()
+
+	apiKey, ok := getAccountAPIKey(c, connection)
+	if !ok {
+		return
+	}
+
+	query := "UPDATE tokens SET revoked_at = NOW() WHERE token_id::text = $1 AND api_key = $2 AND revoked_at IS NULL;"
+	result, err := connection.Exec(context.Background(), query, body.TokenID, apiKey)
+	if err != nil {
+		log.LogToFile(fmt.Sprintf("key=%s: Failed to revoke token - %s", apiKey, err.Error()))
+		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Invalid data."})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "Token not found."})
+		return
+	}
+
+	log.LogToFile(fmt.Sprintf("key=%s: Token '%s' revoked successfully", apiKey, body.TokenID))
+
+	c.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "message": "Token revoked successfully."})
+}
+
+func deleteUserTokens(apiKey string, c *gin.Context, connection *pgx.Conn) error {
+	// Delete all user's tokens
+	query := "DELETE FROM tokens WHERE api_key = $1;"
+	_, err := connection.Exec(context.Background(), query, apiKey)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Invalid API key."})
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package routes
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateTokenRequest(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	tests := []struct {
+		request  TokenRequest
+		expected bool
+	}{
+		{TokenRequest{Label: "Metabase", Scopes: []string{"read"}}, true},
+		{TokenRequest{Scopes: []string{"ingest", "read", "monitor-admin", "delete"}, ExpiresAt: &future}, true},
+		{TokenRequest{Label: "Metabase"}, false},
+		{TokenRequest{Scopes: []string{"admin"}}, false},
+		{TokenRequest{Scopes: []string{"read"}, ExpiresAt: &past}, false},
+		{TokenRequest{Label: "line\nbreak", Scopes: []string{"read"}}, false},
+	}
+
+	for i, test := range tests {
+		err := validateTokenRequest(&test.request)
+		if got := err == nil; got != test.expected {
+			t.Errorf("%d: got %t, expected %t (%v)", i, got, test.expected, err)
+		}
+	}
+}
+
+func TestValidateTokenRequestScopes(t *testing.T) {
+	request := TokenRequest{Scopes: []string{"read", "delete", "read"}}
+	if err := validateTokenRequest(&request); err != nil {
+		t.Fatal(err)
+	}
+	if len(request.Scopes) != 2 || request.Scopes[0] != "read" || request.Scopes[1] != "delete" {
+		t.Errorf("got scopes %v, expected [read delete]", request.Scopes)
+	}
+}
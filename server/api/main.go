@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"time"
 
 	"github.com/tom-draper/api-analytics/server/api/lib/log"
 	"github.com/tom-draper/api-analytics/server/api/lib/routes"
+	"github.com/tom-draper/api-analytics/server/database"
 
 	ratelimit "github.com/JGLTechnologies/gin-rate-limit"
 	"github.com/gin-contrib/cors"
@@ -28,7 +30,11 @@ func main() {
 
 	r := app.Group("/api")
 
-	r.Use(cors.Default())
+	// Allow browsers to read the cursor for the next page of data
+	corsConfig := cors.DefaultConfig()
+	corsConfig.AllowAllOrigins = true
+	corsConfig.ExposeHeaders = []string{"X-Next-Cursor"}
+	r.Use(cors.New(corsConfig))
 
 	// Limit a single IP's request logs to 100 per second
 	store := ratelimit.InMemoryStore(&ratelimit.InMemoryOptions{
@@ -41,7 +47,13 @@ func main() {
 	})
 	app.Use(rateLimiter)
 
-	routes.RegisterRouter(r)
+	pool, err := database.NewPool(context.Background(), database.NewPoolConfig())
+	if err != nil {
+		panic(err)
+	}
+	defer pool.Close()
+
+	routes.RegisterRouter(r, pool)
 
 	app.Run(":3000")
 }
@@ -18,6 +18,8 @@ func getDatabaseURL() string {
 	return url
 }
 
+// NewConnection opens a single connection, panicking if the database is
+// unreachable. Servers and new tools should use NewPool instead.
 func NewConnection() *pgx.Conn {
 	url := getDatabaseURL()
 	conn, err := pgx.Connect(context.Background(), url)
@@ -27,38 +29,32 @@ func NewConnection() *pgx.Conn {
 	return conn
 }
 
-func DeleteUser(apiKey string) error {
-	conn := NewConnection()
-	defer conn.Close(context.Background())
-
+func DeleteUser(ctx context.Context, conn *pgx.Conn, apiKey string) error {
 	query := "DELETE FROM users WHERE api_key = $1;"
-	_, err := conn.Exec(context.Background(), query, apiKey)
+	_, err := conn.Exec(ctx, query, apiKey)
 	return err
 }
 
-func DeleteRequests(apiKey string) error {
-	conn := NewConnection()
-	defer conn.Close(context.Background())
-
+func DeleteRequests(ctx context.Context, conn *pgx.Conn, apiKey string) error {
 	query := "DELETE FROM requests WHERE api_key = $1;"
-	_, err := conn.Exec(context.Background(), query, apiKey)
+	_, err := conn.Exec(ctx, query, apiKey)
 	return err
 }
 
-func DeleteMonitors(apiKey string) error {
-	conn := NewConnection()
-	defer conn.Close(context.Background())
-
+func DeleteMonitors(ctx context.Context, conn *pgx.Conn, apiKey string) error {
 	query := "DELETE FROM monitor WHERE api_key = $1;"
-	_, err := conn.Exec(context.Background(), query, apiKey)
+	_, err := conn.Exec(ctx, query, apiKey)
 	return err
 }
 
-func DeletePings(apiKey string) error {
-	conn := NewConnection()
-	defer conn.Close(context.Background())
-
+func DeletePings(ctx context.Context, conn *pgx.Conn, apiKey string) error {
 	query := "DELETE FROM pings WHERE api_key = $1;"
-	_, err := conn.Exec(context.Background(), query, apiKey)
+	_, err := conn.Exec(ctx, query, apiKey)
+	return err
+}
+
+func DeleteTokens(ctx context.Context, conn *pgx.Conn, apiKey string) error {
+	query := "DELETE FROM tokens WHERE api_key = $1;"
+	_, err := conn.Exec(ctx, query, apiKey)
 	return err
 }
@@ -0,0 +1,2 @@
+-- Number of requests each row represents when sampled by the client middleware
+ALTER TABLE requests ADD COLUMN IF NOT EXISTS weight real NOT NULL DEFAULT 1;
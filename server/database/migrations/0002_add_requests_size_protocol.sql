@@ -0,0 +1,6 @@
+-- Request and response body sizes in bytes, HTTP version and whether the
+-- connection used TLS, as reported by the client middleware
+ALTER TABLE requests ADD COLUMN IF NOT EXISTS request_size bigint;
+ALTER TABLE requests ADD COLUMN IF NOT EXISTS response_size bigint;
+ALTER TABLE requests ADD COLUMN IF NOT EXISTS protocol smallint;
+ALTER TABLE requests ADD COLUMN IF NOT EXISTS tls boolean NOT NULL DEFAULT false;
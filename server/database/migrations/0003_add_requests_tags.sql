@@ -0,0 +1,3 @@
+-- Custom key/value tags attached to each request by the client middleware
+ALTER TABLE requests ADD COLUMN IF NOT EXISTS tags jsonb;
+CREATE INDEX IF NOT EXISTS requests_tags_idx ON requests USING gin (tags jsonb_path_ops);
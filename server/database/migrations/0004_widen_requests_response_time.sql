@@ -0,0 +1,15 @@
+-- Response times are stored in microseconds rather than milliseconds, widened
+-- from smallint so that requests over ~32 seconds can be stored. Only converted
+-- while the column is still smallint so that running again leaves times as
+-- they are.
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'requests'
+            AND column_name = 'response_time' AND data_type = 'smallint'
+    ) THEN
+        ALTER TABLE requests ALTER COLUMN response_time TYPE integer USING response_time::integer * 1000;
+    END IF;
+END
+$$;
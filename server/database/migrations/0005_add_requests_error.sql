@@ -0,0 +1,4 @@
+-- Class of error raised while handling the request (panic, timeout, handler
+-- error or client cancel) and an optional truncated error message
+ALTER TABLE requests ADD COLUMN IF NOT EXISTS error_class smallint;
+ALTER TABLE requests ADD COLUMN IF NOT EXISTS error_message varchar(256);
@@ -0,0 +1,6 @@
+-- Region, city and network inferred from the client IP address when the
+-- GeoLite2-City and GeoLite2-ASN databases are configured
+ALTER TABLE requests ADD COLUMN IF NOT EXISTS region varchar(100);
+ALTER TABLE requests ADD COLUMN IF NOT EXISTS city varchar(100);
+ALTER TABLE requests ADD COLUMN IF NOT EXISTS asn bigint;
+ALTER TABLE requests ADD COLUMN IF NOT EXISTS isp varchar(200);
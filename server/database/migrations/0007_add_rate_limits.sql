@@ -0,0 +1,11 @@
+-- Logged payloads allowed per rate limit window (a minute by default) for each
+-- API key by the logger, overriding the default limit if set
+ALTER TABLE users ADD COLUMN IF NOT EXISTS rate_limit integer;
+
+-- Access counts shared between logger instances when the Postgres rate limit
+-- store is enabled
+CREATE TABLE IF NOT EXISTS rate_limits (
+    api_key varchar(64) PRIMARY KEY,
+    window_start timestamptz NOT NULL,
+    count integer NOT NULL
+);
@@ -0,0 +1,2 @@
+-- Time range scans of an account's requests, used by the aggregation endpoints
+CREATE INDEX IF NOT EXISTS requests_api_key_created_at_idx ON requests (api_key, created_at);
@@ -0,0 +1,15 @@
+-- Revocable tokens granting a subset of the access an API key has, e.g. read
+-- only access for a BI tool. Only a SHA-256 hash of each token is stored.
+CREATE TABLE IF NOT EXISTS tokens (
+    token_id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    token_hash bytea NOT NULL UNIQUE,
+    api_key varchar(64) NOT NULL,
+    label varchar(255) NOT NULL DEFAULT '',
+    scopes text[] NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT NOW(),
+    expires_at timestamptz,
+    last_used_at timestamptz,
+    revoked_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS tokens_api_key_idx ON tokens (api_key);
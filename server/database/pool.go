@@ -0,0 +1,129 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/joho/godotenv"
+)
+
+// ErrUnavailable is returned when a connection cannot be acquired from the
+// pool, typically because the database is unreachable.
+var ErrUnavailable = errors.New("database unavailable")
+
+// PoolConfig holds the connection pool settings.
+type PoolConfig struct {
+	URL               string
+	MaxConns          int32         // Maximum number of open connections
+	MinConns          int32         // Connections kept open when idle
+	MaxConnIdleTime   time.Duration // Idle time before a connection is closed
+	HealthCheckPeriod time.Duration // Interval between checks of idle connections
+	AcquireTimeout    time.Duration // Maximum wait for a connection before giving up
+}
+
+// NewPoolConfig reads the pool settings from the environment, loading .env
+// if present, with defaults for any that are not set.
+func NewPoolConfig() PoolConfig {
+	godotenv.Load(".env")
+
+	return PoolConfig{
+		URL:               os.Getenv("POSTGRES_URL"),
+		MaxConns:          int32(getEnvInt("POSTGRES_MAX_CONNS", 20)),
+		MinConns:          int32(getEnvInt("POSTGRES_MIN_CONNS", 2)),
+		MaxConnIdleTime:   getEnvDuration("POSTGRES_MAX_CONN_IDLE_TIME", 5*time.Minute),
+		HealthCheckPeriod: getEnvDuration("POSTGRES_HEALTH_CHECK_PERIOD", 30*time.Second),
+		AcquireTimeout:    getEnvDuration("POSTGRES_ACQUIRE_TIMEOUT", 5*time.Second),
+	}
+}
+
+func getEnvInt(key string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil && value > 0 {
+		return value
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil && value > 0 {
+		return value
+	}
+	return fallback
+}
+
+// Pool is a pool of database connections shared by request handlers.
+type Pool struct {
+	*pgxpool.Pool
+	acquireTimeout time.Duration
+}
+
+// NewPool creates a connection pool. Connections are opened lazily, so the
+// pool can be created while the database is unreachable.
+func NewPool(ctx context.Context, config PoolConfig) (*Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(config.URL)
+	if err != nil {
+		return nil, err
+	}
+	poolConfig.MaxConns = config.MaxConns
+	poolConfig.MinConns = config.MinConns
+	poolConfig.MaxConnIdleTime = config.MaxConnIdleTime
+	poolConfig.HealthCheckPeriod = config.HealthCheckPeriod
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &Pool{Pool: pool, acquireTimeout: config.AcquireTimeout}, nil
+}
+
+// Acquire returns a connection from the pool, waiting at most the acquire
+// timeout. The connection must be released after use.
+func (p *Pool) Acquire(ctx context.Context) (*pgxpool.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.acquireTimeout)
+	defer cancel()
+
+	conn, err := p.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return conn, nil
+}
+
+// Healthy reports whether a connection can be acquired and the database
+// responds.
+func (p *Pool) Healthy(ctx context.Context) error {
+	conn, err := p.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	return conn.Ping(ctx)
+}
+
+// IsUnavailable reports whether err was caused by the database being
+// unreachable or shutting down, rather than by the query itself.
+func IsUnavailable(err error) bool {
+	if errors.Is(err, ErrUnavailable) || pgconn.Timeout(err) || pgconn.SafeToRetry(err) {
+		return true
+	}
+
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	if errors.As(err, &connectErr) || errors.As(err, &netErr) {
+		return true
+	}
+
+	// Connection exception (08) and operator intervention (57P) errors
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return strings.HasPrefix(pgErr.Code, "08") || strings.HasPrefix(pgErr.Code, "57P")
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// IDs stored in the requests table for each recognised value.
+
+var MethodID = map[string]int16{
+	"GET":     0,
+	"POST":    1,
+	"PUT":     2,
+	"PATCH":   3,
+	"DELETE":  4,
+	"OPTIONS": 5,
+	"CONNECT": 6,
+	"HEAD":    7,
+	"TRACE":   8,
+}
+
+var ProtocolID = map[string]int16{
+	"HTTP/1.0": 0,
+	"HTTP/1.1": 1,
+	"HTTP/2.0": 2,
+	"HTTP/3.0": 3,
+}
+
+var ErrorClassID = map[string]int16{
+	"panic":         0,
+	"timeout":       1,
+	"handler_error": 2,
+	"client_cancel": 3,
+}
+
+var FrameworkID = map[string]int16{
+	"FastAPI":       0,
+	"Flask":         1,
+	"Gin":           2,
+	"Echo":          3,
+	"Express":       4,
+	"Fastify":       5,
+	"Koa":           6,
+	"Chi":           7,
+	"Fiber":         8,
+	"Actix":         9,
+	"Axum":          10,
+	"Tornado":       11,
+	"Django":        12,
+	"Rails":         13,
+	"Laravel":       14,
+	"Sinatra":       15,
+	"Rocket":        16,
+	"ASP.NET Core":  17,
+	"net/http":      18,
+	"Gorilla":       19,
+	"OpenTelemetry": 20,
+	"nginx":         21,
+	"Apache":        22,
+	"AWS ALB":       23,
+}
+
+// Reasons a logged request is rejected, reported back to the client by the
+// logger and counted by the importer.
+const (
+	RejectInvalidMethod    string = "invalid_method"
+	RejectInvalidUserAgent string = "invalid_user_agent"
+	RejectInvalidUserID    string = "invalid_user_id"
+	RejectInvalidHostname  string = "invalid_hostname"
+	RejectInvalidPath      string = "invalid_path"
+	RejectInvalidDate      string = "invalid_date"
+)
+
+// RequestFields holds the text fields of a logged request, sanitised in place
+// by ValidateRequestFields.
+type RequestFields struct {
+	Method    string
+	UserAgent string
+	UserID    string
+	Hostname  string
+	Path      string
+}
+
+// ValidateRequestFields sanitises the text fields of a logged request,
+// returning the ID of its method, or the reason it is rejected if invalid.
+func ValidateRequestFields(fields *RequestFields) (int16, string) {
+	method, ok := MethodID[fields.Method]
+	if !ok {
+		return 0, RejectInvalidMethod
+	}
+
+	fields.UserAgent = SanitizeString(fields.UserAgent, MaxStringLength)
+	if !ValidUserAgent(fields.UserAgent) {
+		return 0, RejectInvalidUserAgent
+	}
+
+	fields.UserID = SanitizeString(fields.UserID, MaxStringLength)
+	if !ValidUserID(fields.UserID) {
+		return 0, RejectInvalidUserID
+	}
+
+	fields.Hostname = SanitizeString(fields.Hostname, MaxStringLength)
+	if !ValidHostname(fields.Hostname) {
+		return 0, RejectInvalidHostname
+	}
+
+	fields.Path = SanitizeString(fields.Path, MaxStringLength)
+	if !ValidPath(fields.Path) {
+		return 0, RejectInvalidPath
+	}
+	return method, ""
+}
+
+// StoreUserAgents inserts any user agents not already in the user_agents
+// table and returns the IDs of all the given user agents.
+func StoreUserAgents(ctx context.Context, conn *pgx.Conn, userAgents map[string]struct{}) (map[string]int, error) {
+	if len(userAgents) == 0 {
+		return map[string]int{}, nil
+	}
+	if err := storeNewUserAgents(ctx, conn, userAgents); err != nil {
+		return nil, err
+	}
+	return getUserAgentIDs(ctx, conn, userAgents)
+}
+
+func storeNewUserAgents(ctx context.Context, conn *pgx.Conn, userAgents map[string]struct{}) error {
+	var query strings.Builder
+	query.WriteString("INSERT INTO user_agents (user_agent) VALUES ")
+	arguments := make([]any, len(userAgents))
+	i := 0
+	for userAgent := range userAgents {
+		query.WriteString(fmt.Sprintf("($%d)", i+1))
+		if i < len(userAgents)-1 {
+			query.WriteString(",")
+		}
+		arguments[i] = userAgent
+		i++
+	}
+	query.WriteString(" ON CONFLICT (user_agent) DO NOTHING;")
+
+	_, err := conn.Exec(ctx, query.String(), arguments...)
+	return err
+}
+
+func getUserAgentIDs(ctx context.Context, conn *pgx.Conn, userAgents map[string]struct{}) (map[string]int, error) {
+	var query strings.Builder
+	query.WriteString("SELECT user_agent, id FROM user_agents WHERE user_agent IN (")
+	arguments := make([]any, len(userAgents))
+	i := 0
+	for userAgent := range userAgents {
+		query.WriteString(fmt.Sprintf("$%d", i+1))
+		if i < len(userAgents)-1 {
+			query.WriteString(",")
+		}
+		arguments[i] = userAgent
+		i++
+	}
+	query.WriteString(");")
+
+	ids := make(map[string]int)
+	rows, err := conn.Query(ctx, query.String(), arguments...)
+	if err != nil {
+		return ids, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userAgent string
+		var id int
+		if err := rows.Scan(&userAgent, &id); err != nil {
+			return ids, err
+		}
+		ids[userAgent] = id
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,30 @@
+package database
+
+import "testing"
+
+func TestValidateRequestFields(t *testing.T) {
+	valid := RequestFields{Method: "GET", UserAgent: "curl/8.0", Hostname: "example.com", Path: "/users"}
+
+	tests := []struct {
+		modify   func(f *RequestFields)
+		expected string
+	}{
+		{func(f *RequestFields) {}, ""},
+		{func(f *RequestFields) { f.Method = "BREW" }, RejectInvalidMethod},
+		{func(f *RequestFields) { f.Hostname = "bad host" }, RejectInvalidHostname},
+		{func(f *RequestFields) { f.UserAgent = "curl\n/8.0" }, ""}, // Control characters removed
+	}
+
+	for i, test := range tests {
+		fields := valid
+		test.modify(&fields)
+		if _, got := ValidateRequestFields(&fields); got != test.expected {
+			t.Errorf("%d: got %q, expected %q", i, got, test.expected)
+		}
+	}
+
+	fields := RequestFields{Method: "POST", UserAgent: "curl\n/8.0", Path: "/"}
+	if method, _ := ValidateRequestFields(&fields); method != MethodID["POST"] || fields.UserAgent != "curl/8.0" {
+		t.Errorf("got method %d and user agent %q, expected %d and curl/8.0", method, fields.UserAgent, MethodID["POST"])
+	}
+}
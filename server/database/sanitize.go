@@ -4,24 +4,55 @@ import (
 	"net"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 func ValidDate(date time.Time) bool {
 	return !date.IsZero()
 }
 
+// MaxStringLength is the maximum length in bytes of the text fields stored
+// for each request.
+const MaxStringLength int = 255
+
+// SanitizeString removes invalid UTF-8 and control characters from a value
+// and truncates it to at most maxLength bytes without splitting a multi-byte
+// character.
+func SanitizeString(value string, maxLength int) string {
+	value = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(value, ""))
+	if len(value) > maxLength {
+		n := maxLength
+		for n > 0 && !utf8.RuneStart(value[n]) {
+			n--
+		}
+		value = value[:n]
+	}
+	return value
+}
+
+// ValidString checks a value is valid UTF-8 within the maximum length and
+// free of control characters. Values are always passed to queries as
+// parameters, so no other characters need to be excluded.
 func ValidString(value string) bool {
-	value = strings.ToUpper(value)
-	return (!strings.Contains(value, "DROP TABLE") &&
-		!strings.Contains(value, "INSERT") &&
-		!strings.Contains(value, "UPDATE") &&
-		!strings.Contains(value, "SELECT") &&
-		!strings.Contains(value, "--") &&
-		!strings.Contains(value, "'"))
+	if len(value) > MaxStringLength || !utf8.ValidString(value) {
+		return false
+	}
+	for _, r := range value {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
 }
 
 func ValidHostname(hostname string) bool {
-	return ValidString(hostname)
+	return ValidString(hostname) && strings.IndexFunc(hostname, unicode.IsSpace) < 0
 }
 
 func ValidPath(path string) bool {
@@ -36,11 +67,11 @@ func ValidUserID(userID string) bool {
 	return ValidString(userID)
 }
 
+// ValidLocation checks a location is a two letter ISO country code.
 func ValidLocation(location string) bool {
-	return (len(location) == 2 &&
-		!strings.Contains(location, "--") &&
-		!strings.Contains(location, ";") &&
-		!strings.Contains(location, "'"))
+	return len(location) == 2 &&
+		location[0] >= 'A' && location[0] <= 'Z' &&
+		location[1] >= 'A' && location[1] <= 'Z'
 }
 
 func ValidStatus(status int) bool {
@@ -54,3 +85,28 @@ func ValidIPAddress(ipAddress string) bool {
 	ip := net.ParseIP(ipAddress)
 	return ip != nil
 }
+
+// Limits on the custom tags attached to each logged request
+const (
+	MaxTags           int = 10
+	MaxTagKeyLength   int = 32
+	MaxTagValueLength int = 64
+)
+
+// ValidTagKey checks a tag key is a short identifier made up of letters,
+// digits, underscores, dashes and dots.
+func ValidTagKey(key string) bool {
+	if key == "" || len(key) > MaxTagKeyLength {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func ValidTagValue(value string) bool {
+	return len(value) <= MaxTagValueLength && ValidString(value)
+}
@@ -0,0 +1,63 @@
+package database
+
+import "testing"
+
+func TestValidString(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected bool
+	}{
+		{"/api/select-plan", true},
+		{"Mozilla/5.0 (it's a browser)", true},
+		{"/api/users--old", true},
+		{"café", true},
+		{"line\nbreak", false},
+		{"null\x00byte", false},
+		{"\xff\xfe", false},
+		{string(make([]byte, MaxStringLength+1)), false},
+	}
+
+	for i, test := range tests {
+		if got := ValidString(test.value); got != test.expected {
+			t.Errorf("%d: got %t, expected %t", i, got, test.expected)
+		}
+	}
+}
+
+func TestSanitizeString(t *testing.T) {
+	tests := []struct {
+		value     string
+		maxLength int
+		expected  string
+	}{
+		{"/api/select-plan", 255, "/api/select-plan"},
+		{"tab\tand\x00null", 255, "tabandnull"},
+		{"bad\xffutf8", 255, "badutf8"},
+		{"café", 4, "caf"},
+	}
+
+	for i, test := range tests {
+		if got := SanitizeString(test.value, test.maxLength); got != test.expected {
+			t.Errorf("%d: got %q, expected %q", i, got, test.expected)
+		}
+	}
+}
+
+func TestValidIPAddress(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected bool
+	}{
+		{"192.168.0.1", true},
+		{"2001:db8::1", true},
+		{"", false},
+		{"256.0.0.1", false},
+		{"localhost", false},
+	}
+
+	for i, test := range tests {
+		if got := ValidIPAddress(test.value); got != test.expected {
+			t.Errorf("%d: got %t, expected %t", i, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Scopes a token can be granted. An API key has every scope.
+const (
+	ScopeIngest       string = "ingest"
+	ScopeRead         string = "read"
+	ScopeMonitorAdmin string = "monitor-admin"
+	ScopeDelete       string = "delete"
+)
+
+var Scopes = []string{ScopeIngest, ScopeRead, ScopeMonitorAdmin, ScopeDelete}
+
+// TokenPrefix starts every token, distinguishing them from API keys.
+const TokenPrefix string = "aat_"
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenScope   = errors.New("token does not have scope")
+)
+
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsToken reports whether a credential is a token rather than an API key.
+func IsToken(credential string) bool {
+	return strings.HasPrefix(credential, TokenPrefix)
+}
+
+// NewToken generates a random token.
+func NewToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return TokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hash a token is stored and looked up by.
+func HashToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// ResolveToken returns the API key of the account a token belongs to,
+// recording that it was used. Returns ErrInvalidToken if the token is unknown,
+// expired or revoked, and ErrTokenScope if it was not granted the scope.
+func ResolveToken(ctx context.Context, conn *pgx.Conn, token string, scope string) (string, error) {
+	var apiKey string
+	var scopes []string
+	query := "UPDATE tokens SET last_used_at = NOW() WHERE token_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW()) RETURNING api_key, scopes;"
+	err := conn.QueryRow(ctx, query, HashToken(token)).Scan(&apiKey, &scopes)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrInvalidToken
+	} else if err != nil {
+		return "", err
+	}
+
+	for _, s := range scopes {
+		if s == scope {
+			return apiKey, nil
+		}
+	}
+	return "", ErrTokenScope
+}
@@ -0,0 +1,43 @@
+package database
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNewToken(t *testing.T) {
+	token, err := NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := NewToken()
+	if !IsToken(token) || token == other {
+		t.Errorf("got tokens %q and %q", token, other)
+	}
+	if IsToken("b56cbd92-1168-4d7b-8d94-0418da207908") {
+		t.Error("got API key as token")
+	}
+	if !bytes.Equal(HashToken(token), HashToken(token)) || bytes.Equal(HashToken(token), HashToken(other)) {
+		t.Error("got inconsistent token hashes")
+	}
+}
+
+func TestValidScope(t *testing.T) {
+	tests := []struct {
+		scope    string
+		expected bool
+	}{
+		{"ingest", true},
+		{"read", true},
+		{"monitor-admin", true},
+		{"delete", true},
+		{"admin", false},
+		{"", false},
+	}
+
+	for i, test := range tests {
+		if got := ValidScope(test.scope); got != test.expected {
+			t.Errorf("%d: got %t, expected %t", i, got, test.expected)
+		}
+	}
+}
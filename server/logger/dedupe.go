@@ -0,0 +1,138 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Time a batch ID is remembered after it was last seen
+const defaultDedupeWindow time.Duration = 10 * time.Minute
+
+// Maximum length of a client batch ID
+const maxBatchIDLength int = 64
+
+// dedupeWindow remembers the rows recently accepted from each client batch,
+// so that a batch sent again after an ambiguous failure is acknowledged
+// without its rows being stored twice. Rows are only acknowledged as
+// duplicates once written; a batch sent again while its rows are still queued
+// is asked to retry later, as the write may yet fail.
+type dedupeWindow struct {
+	ttl time.Duration
+
+	mu          sync.Mutex
+	batches     map[batchKey]*seenBatch
+	lastEvicted time.Time
+}
+
+type batchKey struct {
+	apiKey  string
+	batchID string
+}
+
+type seenBatch struct {
+	sequences map[int64]bool // Whether each claimed row has been written
+	expires   time.Time
+}
+
+func newDedupeWindow(ttl time.Duration) *dedupeWindow {
+	return &dedupeWindow{ttl: ttl, batches: make(map[batchKey]*seenBatch)}
+}
+
+// claim removes rows already written from an earlier upload of the batch and
+// records the remaining rows as claimed, returning the number removed. Nothing
+// is claimed and false is returned if any of the rows from an earlier upload
+// are still waiting to be written.
+func (d *dedupeWindow) claim(b *batch, batchID string) (int, bool) {
+	now := time.Now()
+	key := batchKey{apiKey: b.apiKey, batchID: batchID}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.evict(now)
+	seen, ok := d.batches[key]
+	if !ok {
+		seen = &seenBatch{sequences: make(map[int64]bool, len(b.rows))}
+		d.batches[key] = seen
+	}
+	seen.expires = now.Add(d.ttl)
+
+	for _, r := range b.rows {
+		if written, ok := seen.sequences[r.sequence]; ok && !written {
+			return 0, false
+		}
+	}
+
+	rows := b.rows[:0]
+	for _, r := range b.rows {
+		if _, ok := seen.sequences[r.sequence]; ok {
+			continue
+		}
+		seen.sequences[r.sequence] = false
+		rows = append(rows, r)
+	}
+	duplicates := len(b.rows) - len(rows)
+	b.rows = rows
+	return duplicates, true
+}
+
+// commit records the claimed rows of a batch as written, so that they are
+// acknowledged as duplicates if sent again.
+func (d *dedupeWindow) commit(b batch, batchID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	seen, ok := d.batches[batchKey{apiKey: b.apiKey, batchID: batchID}]
+	if !ok {
+		return
+	}
+	for _, r := range b.rows {
+		if _, ok := seen.sequences[r.sequence]; ok {
+			seen.sequences[r.sequence] = true
+		}
+	}
+}
+
+// release forgets the rows of a batch claimed but not written, allowing them
+// to be sent again.
+func (d *dedupeWindow) release(b batch, batchID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	seen, ok := d.batches[batchKey{apiKey: b.apiKey, batchID: batchID}]
+	if !ok {
+		return
+	}
+	for _, r := range b.rows {
+		delete(seen.sequences, r.sequence)
+	}
+}
+
+// validSequences checks each request in a batch has a unique sequence number
+// from 1, as retried rows are recognised by it.
+func validSequences(requests []RequestData) bool {
+	sequences := make(map[int64]struct{}, len(requests))
+	for _, request := range requests {
+		if request.Sequence <= 0 {
+			return false
+		}
+		if _, ok := sequences[request.Sequence]; ok {
+			return false
+		}
+		sequences[request.Sequence] = struct{}{}
+	}
+	return true
+}
+
+// evict removes expired batches, at most once per window.
+func (d *dedupeWindow) evict(now time.Time) {
+	if now.Sub(d.lastEvicted) < d.ttl {
+		return
+	}
+	for key, seen := range d.batches {
+		if now.After(seen.expires) {
+			delete(d.batches, key)
+		}
+	}
+	d.lastEvicted = now
+}
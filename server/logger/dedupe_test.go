@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestBatch(apiKey string, sequences ...int64) batch {
+	b := batch{apiKey: apiKey}
+	for _, sequence := range sequences {
+		b.rows = append(b.rows, row{sequence: sequence})
+	}
+	return b
+}
+
+func TestDedupeClaim(t *testing.T) {
+	d := newDedupeWindow(time.Minute)
+
+	tests := []struct {
+		batch      batch
+		batchID    string
+		duplicates int
+	}{
+		{newTestBatch("a", 0, 1, 2), "1", 0},
+		{newTestBatch("a", 0, 1, 2), "1", 3}, // Retried
+		{newTestBatch("a", 2, 3), "1", 1},    // Partially retried
+		{newTestBatch("a", 0, 1), "2", 0},    // New batch
+		{newTestBatch("b", 0, 1), "1", 0},    // Same batch ID from another API key
+	}
+
+	for i, test := range tests {
+		rows := len(test.batch.rows)
+		got, ok := d.claim(&test.batch, test.batchID)
+		if !ok || got != test.duplicates {
+			t.Errorf("%d: got %d duplicates (%t), expected %d", i, got, ok, test.duplicates)
+		}
+		if len(test.batch.rows) != rows-test.duplicates {
+			t.Errorf("%d: got %d rows, expected %d", i, len(test.batch.rows), rows-test.duplicates)
+		}
+		d.commit(test.batch, test.batchID)
+	}
+}
+
+func TestDedupeClaimPending(t *testing.T) {
+	d := newDedupeWindow(time.Minute)
+
+	b := newTestBatch("a", 1, 2)
+	d.claim(&b, "1")
+
+	// Sent again before the first upload is written
+	retry := newTestBatch("a", 2, 3)
+	if got, ok := d.claim(&retry, "1"); ok || len(retry.rows) != 2 {
+		t.Errorf("got %d duplicates (%t) with %d rows, expected pending with 2 rows", got, ok, len(retry.rows))
+	}
+	// Rows not sent before are not claimed by the rejected upload
+	other := newTestBatch("a", 3)
+	if got, ok := d.claim(&other, "1"); !ok || got != 0 {
+		t.Errorf("got %d duplicates (%t), expected 0", got, ok)
+	}
+
+	d.commit(b, "1")
+	retry = newTestBatch("a", 1, 2)
+	if got, ok := d.claim(&retry, "1"); !ok || got != 2 {
+		t.Errorf("got %d duplicates (%t) after write, expected 2", got, ok)
+	}
+}
+
+func TestDedupeRelease(t *testing.T) {
+	d := newDedupeWindow(time.Minute)
+
+	b := newTestBatch("a", 0, 1)
+	d.claim(&b, "1")
+	d.release(b, "1")
+
+	retry := newTestBatch("a", 0, 1)
+	if got, ok := d.claim(&retry, "1"); !ok || got != 0 {
+		t.Errorf("got %d duplicates (%t) after release, expected 0", got, ok)
+	}
+}
+
+func TestDedupeExpiry(t *testing.T) {
+	d := newDedupeWindow(time.Millisecond)
+
+	b := newTestBatch("a", 0)
+	d.claim(&b, "1")
+	time.Sleep(5 * time.Millisecond)
+
+	retry := newTestBatch("a", 0)
+	if got, ok := d.claim(&retry, "1"); !ok || got != 0 {
+		t.Errorf("got %d duplicates (%t) after expiry, expected 0", got, ok)
+	}
+	if len(d.batches) != 1 {
+		t.Errorf("got %d batches remembered, expected 1", len(d.batches))
+	}
+}
+
+func TestValidSequences(t *testing.T) {
+	tests := []struct {
+		sequences []int64
+		expected  bool
+	}{
+		{[]int64{1, 2, 3}, true},
+		{[]int64{3, 1}, true},
+		{[]int64{0, 1, 2}, false}, // Missing
+		{[]int64{1, 2, 2}, false},
+		{[]int64{-1}, false},
+	}
+
+	for i, test := range tests {
+		requests := make([]RequestData, len(test.sequences))
+		for j, sequence := range test.sequences {
+			requests[j].Sequence = sequence
+		}
+		if got := validSequences(requests); got != test.expected {
+			t.Errorf("%d: got %t, expected %t", i, got, test.expected)
+		}
+	}
+}
+
+func TestDedupeWriteResult(t *testing.T) {
+	// Failures are logged to requests.log in the working directory
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	d := newDedupeWindow(time.Minute)
+	q := &queue{dedupe: d}
+
+	b := newTestBatch("a", 1, 2)
+	b.batchID = "1"
+	d.claim(&b, b.batchID)
+	q.recordResult([]batch{b}, errors.New("copy failed"))
+
+	retry := newTestBatch("a", 1, 2)
+	if got, ok := d.claim(&retry, "1"); !ok || got != 0 {
+		t.Errorf("got %d duplicates (%t) after failed write, expected 0", got, ok)
+	}
+
+	retry.batchID = "1"
+	q.recordResult([]batch{retry}, nil)
+	retry = newTestBatch("a", 1, 2)
+	if got, ok := d.claim(&retry, "1"); !ok || got != 2 {
+		t.Errorf("got %d duplicates (%t) after write, expected 2", got, ok)
+	}
+}
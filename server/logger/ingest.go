@@ -0,0 +1,52 @@
+package main
+
+import "github.com/tom-draper/api-analytics/server/database"
+
+// Reasons a logged request is rejected, reported back to the client.
+const (
+	rejectInvalidMethod    string = database.RejectInvalidMethod
+	rejectInvalidUserAgent string = database.RejectInvalidUserAgent
+	rejectInvalidUserID    string = database.RejectInvalidUserID
+	rejectInvalidHostname  string = database.RejectInvalidHostname
+	rejectInvalidPath      string = database.RejectInvalidPath
+	rejectInvalidDate      string = database.RejectInvalidDate
+	rejectOverLimit        string = "over_limit"
+)
+
+// Number of indexes of rejected requests returned for each reason
+const maxRejectionSamples int = 10
+
+// Rejection counts the requests in a payload rejected for a reason, with a
+// sample of their indexes.
+type Rejection struct {
+	Count   int   `json:"count"`
+	Indexes []int `json:"indexes"`
+}
+
+// IngestResponse reports the logged requests accepted from a payload and the
+// reasons any were rejected.
+type IngestResponse struct {
+	Status     int                   `json:"status"`
+	Message    string                `json:"message"`
+	Accepted   int                   `json:"accepted"`
+	Rejected   int                   `json:"rejected"`
+	Duplicates int                   `json:"duplicates"` // Already accepted from an earlier upload of the batch
+	Rejections map[string]*Rejection `json:"rejections"`
+}
+
+func newIngestResponse() *IngestResponse {
+	return &IngestResponse{Rejections: make(map[string]*Rejection)}
+}
+
+func (r *IngestResponse) reject(reason string, index int) {
+	r.Rejected++
+	rejection, ok := r.Rejections[reason]
+	if !ok {
+		rejection = &Rejection{Indexes: []int{}}
+		r.Rejections[reason] = rejection
+	}
+	rejection.Count++
+	if len(rejection.Indexes) < maxRejectionSamples {
+		rejection.Indexes = append(rejection.Indexes, index)
+	}
+}
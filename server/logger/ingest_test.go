@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRejectionSamples(t *testing.T) {
+	response := newIngestResponse()
+	for i := 0; i < maxRejectionSamples+5; i++ {
+		response.reject(rejectInvalidPath, i)
+	}
+	response.reject(rejectInvalidMethod, 20)
+
+	if response.Rejected != maxRejectionSamples+6 {
+		t.Errorf("got %d rejected, expected %d", response.Rejected, maxRejectionSamples+6)
+	}
+	rejection := response.Rejections[rejectInvalidPath]
+	if rejection.Count != maxRejectionSamples+5 || len(rejection.Indexes) != maxRejectionSamples {
+		t.Errorf("got count %d with %d indexes, expected %d with %d", rejection.Count, len(rejection.Indexes), maxRejectionSamples+5, maxRejectionSamples)
+	}
+	if rejection := response.Rejections[rejectInvalidMethod]; rejection.Count != 1 || rejection.Indexes[0] != 20 {
+		t.Errorf("got %+v, expected index 20", rejection)
+	}
+}
+
+func TestRejectInvalidDate(t *testing.T) {
+	payload := Payload{Requests: []RequestData{
+		{Path: "/", UserAgent: "curl/8.0", Method: "GET", Status: 200, CreatedAt: "2024-01-02T03:04:05+01:00"},
+		{Path: "/", UserAgent: "curl/8.0", Method: "GET", Status: 200, CreatedAt: "02/01/2024"},
+		{Path: "/", UserAgent: "curl/8.0", Method: "GET", Status: 200},
+	}}
+	b, response := newBatch(payload, 0)
+	if len(b.rows) != 1 || !b.rows[0].createdAt.Equal(time.Date(2024, 1, 2, 2, 4, 5, 0, time.UTC)) {
+		t.Errorf("got rows %+v, expected one at 02:04:05 UTC", b.rows)
+	}
+	if rejection := response.Rejections[rejectInvalidDate]; rejection == nil || rejection.Count != 2 {
+		t.Errorf("got %+v, expected 2 invalid dates", response.Rejections)
+	}
+}
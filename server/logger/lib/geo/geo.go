@@ -0,0 +1,216 @@
+package geo
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/tom-draper/api-analytics/server/logger/lib/log"
+)
+
+const DefaultCountryPath string = "GeoLite2-Country.mmdb"
+
+type Config struct {
+	CountryPath    string        // GeoLite2-Country database
+	CityPath       string        // Optional GeoLite2-City database for region and city
+	ASNPath        string        // Optional GeoLite2-ASN database for network and ISP
+	ReloadInterval time.Duration // Interval between checks for updated database files
+}
+
+// NewConfig reads the database paths from the environment, with the country
+// database defaulting to the working directory.
+func NewConfig() Config {
+	config := Config{
+		CountryPath:    os.Getenv("GEOIP_COUNTRY_PATH"),
+		CityPath:       os.Getenv("GEOIP_CITY_PATH"),
+		ASNPath:        os.Getenv("GEOIP_ASN_PATH"),
+		ReloadInterval: time.Minute,
+	}
+	if config.CountryPath == "" {
+		config.CountryPath = DefaultCountryPath
+	}
+	return config
+}
+
+// Location holds the details inferred from an IP address. Fields are empty if
+// the corresponding database is not configured or has no record.
+type Location struct {
+	Country string // ISO country code
+	Region  string
+	City    string
+	ASN     uint
+	ISP     string
+}
+
+// database is an open reader along with the modification time of the file it
+// was read from.
+type database struct {
+	reader  *geoip2.Reader
+	modTime time.Time
+}
+
+// Locator looks up the location of IP addresses, keeping the GeoLite2
+// databases open and reloading them when the files change.
+type Locator struct {
+	config Config
+
+	mu      sync.RWMutex
+	country *database
+	city    *database
+	asn     *database
+
+	reloadMu sync.Mutex
+	failures map[string]string // Last error logged for each path, logged once until it changes
+}
+
+func NewLocator(config Config) *Locator {
+	l := &Locator{config: config, failures: make(map[string]string)}
+	l.Reload(false)
+	return l
+}
+
+// Lookup returns the location of an IP address.
+func (l *Locator) Lookup(ipAddress string) Location {
+	var location Location
+	ip := net.ParseIP(ipAddress)
+	if ip == nil {
+		return location
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.city != nil {
+		if record, err := l.city.reader.City(ip); err == nil {
+			location.Country = record.Country.IsoCode
+			location.City = record.City.Names["en"]
+			if len(record.Subdivisions) > 0 {
+				location.Region = record.Subdivisions[0].Names["en"]
+			}
+		}
+	}
+	if location.Country == "" && l.country != nil {
+		if record, err := l.country.reader.Country(ip); err == nil {
+			location.Country = record.Country.IsoCode
+		}
+	}
+	if l.asn != nil {
+		if record, err := l.asn.reader.ASN(ip); err == nil {
+			location.ASN = record.AutonomousSystemNumber
+			location.ISP = record.AutonomousSystemOrganization
+		}
+	}
+	return location
+}
+
+// Reload reopens any database files modified since they were last opened, or
+// all of them if force is set. A database that fails to open is logged and
+// the previous version kept.
+func (l *Locator) Reload(force bool) {
+	l.reloadMu.Lock()
+	defer l.reloadMu.Unlock()
+
+	l.mu.RLock()
+	country := l.reopen(l.country, l.config.CountryPath, force)
+	city := l.reopen(l.city, l.config.CityPath, force)
+	asn := l.reopen(l.asn, l.config.ASNPath, force)
+	l.mu.RUnlock()
+
+	l.mu.Lock()
+	old := []*database{}
+	if country != l.country {
+		old = append(old, l.country)
+		l.country = country
+	}
+	if city != l.city {
+		old = append(old, l.city)
+		l.city = city
+	}
+	if asn != l.asn {
+		old = append(old, l.asn)
+		l.asn = asn
+	}
+	l.mu.Unlock()
+
+	// Safe to close as lookups holding the read lock have finished
+	for _, db := range old {
+		if db != nil {
+			db.reader.Close()
+		}
+	}
+}
+
+// reopen returns a newly opened database if the file at path has changed,
+// otherwise the current database.
+func (l *Locator) reopen(current *database, path string, force bool) *database {
+	if path == "" {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		l.logFailure(path, fmt.Sprintf("GeoLite2 database unavailable - %s", err.Error()))
+		return current
+	}
+	if !force && current != nil && info.ModTime().Equal(current.modTime) {
+		return current
+	}
+
+	reader, err := geoip2.Open(path)
+	if err != nil {
+		l.logFailure(path, fmt.Sprintf("GeoLite2 database failed to open - %s", err.Error()))
+		return current
+	}
+	delete(l.failures, path)
+	return &database{reader: reader, modTime: info.ModTime()}
+}
+
+// logFailure logs an error opening a database, unless it is the same error
+// last logged for that path, so a missing file is not logged on every reload.
+func (l *Locator) logFailure(path string, msg string) {
+	if l.failures[path] == msg {
+		return
+	}
+	l.failures[path] = msg
+	log.LogToFile(msg)
+}
+
+// Watch reloads the databases when their files change, checked on each
+// reload interval, or when the process receives SIGHUP, until ctx is done.
+func (l *Locator) Watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(l.config.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.Reload(false)
+		case <-hup:
+			log.LogToFile("Reloading GeoLite2 databases...")
+			l.Reload(true)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Close closes any open databases.
+func (l *Locator) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, db := range []*database{l.country, l.city, l.asn} {
+		if db != nil {
+			db.reader.Close()
+		}
+	}
+	l.country, l.city, l.asn = nil, nil, nil
+}
@@ -0,0 +1,121 @@
+package geo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mmdbString encodes a UTF-8 string in the MaxMind DB data format.
+func mmdbString(s string) []byte {
+	return append([]byte{0x40 | byte(len(s))}, s...)
+}
+
+// mmdbMap encodes a map of string keys to encoded values, in the given order.
+func mmdbMap(pairs ...[]byte) []byte {
+	b := []byte{0xe0 | byte(len(pairs)/2)}
+	for _, pair := range pairs {
+		b = append(b, pair...)
+	}
+	return b
+}
+
+// writeCountryDatabase writes a minimal GeoLite2-Country database recording
+// every IPv4 address in the given country.
+func writeCountryDatabase(t *testing.T, path string, isoCode string) {
+	t.Helper()
+
+	// One node, both records pointing to the start of the data section
+	const nodeCount = 1
+	pointer := nodeCount + 16
+	tree := []byte{0, 0, byte(pointer), 0, 0, byte(pointer)}
+
+	data := mmdbMap(mmdbString("country"), mmdbMap(mmdbString("iso_code"), mmdbString(isoCode)))
+
+	metadata := mmdbMap(
+		mmdbString("node_count"), []byte{0xc1, nodeCount},
+		mmdbString("record_size"), []byte{0xa1, 24},
+		mmdbString("ip_version"), []byte{0xa1, 4},
+		mmdbString("database_type"), mmdbString("GeoLite2-Country"),
+		mmdbString("languages"), []byte{0x00, 0x04},
+		mmdbString("binary_format_major_version"), []byte{0xa1, 2},
+		mmdbString("binary_format_minor_version"), []byte{0xa0},
+		mmdbString("build_epoch"), []byte{0x00, 0x02},
+		mmdbString("description"), mmdbMap(),
+	)
+
+	var b []byte
+	b = append(b, tree...)
+	b = append(b, make([]byte, 16)...)
+	b = append(b, data...)
+	b = append(b, "\xab\xcd\xefMaxMind.com"...)
+	b = append(b, metadata...)
+	if err := os.WriteFile(path, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// chdir moves to a temporary directory for the test, as failures are logged
+// to requests.log in the working directory.
+func chdir(t *testing.T) string {
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+func TestLocatorReload(t *testing.T) {
+	dir := chdir(t)
+	path := filepath.Join(dir, "GeoLite2-Country.mmdb")
+	writeCountryDatabase(t, path, "GB")
+
+	locator := NewLocator(Config{CountryPath: path})
+	defer locator.Close()
+	if got := locator.Lookup("203.0.113.7").Country; got != "GB" {
+		t.Errorf("got country %q, expected GB", got)
+	}
+
+	// Unchanged file kept open
+	locator.Reload(false)
+	if got := locator.Lookup("203.0.113.7").Country; got != "GB" {
+		t.Errorf("got country %q after reload, expected GB", got)
+	}
+
+	writeCountryDatabase(t, path, "FR")
+	modTime := time.Now().Add(time.Minute)
+	os.Chtimes(path, modTime, modTime)
+	locator.Reload(false)
+	if got := locator.Lookup("203.0.113.7").Country; got != "FR" {
+		t.Errorf("got country %q after update, expected FR", got)
+	}
+}
+
+func TestLocatorMissingFile(t *testing.T) {
+	dir := chdir(t)
+	path := filepath.Join(dir, "GeoLite2-Country.mmdb")
+
+	locator := NewLocator(Config{CountryPath: path})
+	defer locator.Close()
+	if got := locator.Lookup("203.0.113.7"); got != (Location{}) {
+		t.Errorf("got location %+v, expected none", got)
+	}
+
+	// Missing file logged once rather than on every reload
+	for i := 0; i < 3; i++ {
+		locator.Reload(false)
+	}
+	logs, _ := os.ReadFile(filepath.Join(dir, "requests.log"))
+	if count := strings.Count(string(logs), "GeoLite2 database unavailable"); count != 1 {
+		t.Errorf("got missing file logged %d times, expected 1", count)
+	}
+
+	// Opened once the file appears
+	writeCountryDatabase(t, path, "GB")
+	locator.Reload(false)
+	if got := locator.Lookup("203.0.113.7").Country; got != "GB" {
+		t.Errorf("got country %q, expected GB", got)
+	}
+}
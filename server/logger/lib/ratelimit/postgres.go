@@ -0,0 +1,36 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/tom-draper/api-analytics/server/database"
+)
+
+// PostgresStore counts accesses in the rate_limits table, in fixed windows
+// starting on multiples of the window length.
+type PostgresStore struct {
+	pool *database.Pool
+}
+
+func NewPostgresStore(pool *database.Pool) *PostgresStore {
+	return &PostgresStore{pool: pool}
+}
+
+func (s *PostgresStore) Increment(ctx context.Context, apiKey string, window time.Duration) (int, time.Time, error) {
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer conn.Release()
+
+	windowStart := time.Now().Truncate(window)
+	query := `INSERT INTO rate_limits (api_key, window_start, count) VALUES ($1, $2, 1)
+		ON CONFLICT (api_key) DO UPDATE SET
+			count = CASE WHEN rate_limits.window_start = EXCLUDED.window_start THEN rate_limits.count + 1 ELSE 1 END,
+			window_start = EXCLUDED.window_start
+		RETURNING count;`
+	var count int
+	err = conn.QueryRow(ctx, query, apiKey, windowStart).Scan(&count)
+	return count, windowStart.Add(window), err
+}
@@ -1,16 +1,74 @@
 package ratelimit
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
 	"time"
+
+	"github.com/tom-draper/api-analytics/server/logger/lib/log"
+)
+
+const (
+	DefaultLimit  int           = 10 // Accesses allowed per window
+	DefaultWindow time.Duration = time.Minute
+	DefaultTTL    time.Duration = 10 * time.Minute // Idle time before an API key is forgotten
 )
 
-type RateLimiter map[string]*userRate
+// Store counts accesses in a store shared between logger instances, so that
+// they enforce a single quota.
+type Store interface {
+	// Increment records an access by the API key, returning the number of
+	// accesses in the current window and when the window resets.
+	Increment(ctx context.Context, apiKey string, window time.Duration) (int, time.Time, error)
+}
+
+// RateLimiter limits the number of accesses made with each API key within a
+// sliding window. The zero value is ready to use with the default limits and
+// is safe for concurrent use.
+type RateLimiter struct {
+	Limit    int                             // Accesses allowed per window, unless overridden by GetLimit
+	Window   time.Duration                   // Period accesses are counted over
+	TTL      time.Duration                   // Idle time before an API key is evicted
+	GetLimit func(apiKey string) (int, bool) // Optional lookup of the limit for a specific API key
+	Store    Store                           // Optional shared store, with accesses counted locally if nil or unavailable
 
-const accessesPerMinute int = 10
+	mu          sync.Mutex
+	users       map[string]*userRate
+	lastEvicted time.Time
+}
+
+// Result describes the state of the rate limit for an API key after an
+// access.
+type Result struct {
+	Limit     int
+	Remaining int       // Accesses left in the current window
+	Reset     time.Time // When an access in the current window expires, freeing a slot
+	Limited   bool
+}
+
+// SetHeaders writes the X-RateLimit-* headers describing the result, and
+// Retry-After if the access was limited.
+func (r Result) SetHeaders(header http.Header) {
+	header.Set("X-RateLimit-Limit", strconv.Itoa(r.Limit))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(r.Remaining))
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(r.Reset.Unix(), 10))
+	if r.Limited {
+		retryAfter := int(time.Until(r.Reset).Seconds()) + 1
+		header.Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+}
 
 type userRate struct {
-	timestamps [accessesPerMinute]time.Time // Circular array
-	current    int                          // Index of oldest timestamp to be replaced next
+	timestamps []time.Time // Circular array, one slot per access allowed
+	current    int         // Index of oldest timestamp to be replaced next
+	lastAccess time.Time
+}
+
+func newUserRate(limit int) *userRate {
+	return &userRate{timestamps: make([]time.Time, limit)}
 }
 
 func (u *userRate) increment() {
@@ -21,38 +79,116 @@ func (u *userRate) increment() {
 	}
 }
 
-func (u *userRate) rateLimited() bool {
-	oldest := u.timestamps[u.current]
+func (u *userRate) allow(now time.Time, window time.Duration) Result {
+	u.lastAccess = now
+	result := Result{Limit: len(u.timestamps)}
 
-	// If the oldest timestamp recorded is less than a minute ago => rate limited
-	if time.Since(oldest) < time.Minute {
+	// If the oldest timestamp recorded is within the window => rate limited
+	oldest := u.timestamps[u.current]
+	if now.Sub(oldest) < window {
 		// User access denied, do not record attempted access
-		return true
-	} else {
-		u.recordAccess() // User access successful, register access and record current time
-		return false
+		result.Limited = true
+		result.Reset = oldest.Add(window)
+		return result
 	}
-}
 
-func (u *userRate) recordAccess() {
-	u.timestamps[u.current] = time.Now()
+	// User access successful, register access and record current time
+	u.timestamps[u.current] = now
 	u.increment()
+
+	// Window resets as the oldest access within it expires
+	result.Reset = now.Add(window)
+	for _, timestamp := range u.timestamps {
+		if now.Sub(timestamp) >= window {
+			result.Remaining++
+		} else if timestamp.Add(window).Before(result.Reset) {
+			result.Reset = timestamp.Add(window)
+		}
+	}
+	return result
 }
 
-func newUserRate() *userRate {
-	ur := userRate{}
-	ur.recordAccess()
-	return &ur
+func (r *RateLimiter) limit(apiKey string) int {
+	if r.GetLimit != nil {
+		if limit, ok := r.GetLimit(apiKey); ok && limit > 0 {
+			return limit
+		}
+	}
+	if r.Limit > 0 {
+		return r.Limit
+	}
+	return DefaultLimit
 }
 
-func (r RateLimiter) RateLimited(apiKey string) bool {
-	ur, ok := r[apiKey]
+func (r *RateLimiter) window() time.Duration {
+	if r.Window > 0 {
+		return r.Window
+	}
+	return DefaultWindow
+}
 
-	if ok {
-		return ur.rateLimited()
-	} else {
-		// Add new API key to rate limiter
-		r[apiKey] = newUserRate()
-		return false
+func (r *RateLimiter) ttl() time.Duration {
+	if r.TTL > 0 {
+		return r.TTL
+	}
+	return DefaultTTL
+}
+
+// Allow records an access made with the API key, if it is within the limit.
+func (r *RateLimiter) Allow(ctx context.Context, apiKey string) Result {
+	limit := r.limit(apiKey)
+	if r.Store != nil {
+		count, reset, err := r.Store.Increment(ctx, apiKey, r.window())
+		if err == nil {
+			result := Result{Limit: limit, Reset: reset, Limited: count > limit}
+			if !result.Limited {
+				result.Remaining = limit - count
+			}
+			return result
+		}
+		log.LogToFile(fmt.Sprintf("Rate limit store unavailable - %s", err.Error()))
+	}
+	return r.allow(apiKey, limit, time.Now())
+}
+
+func (r *RateLimiter) allow(apiKey string, limit int, now time.Time) Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.users == nil {
+		r.users = make(map[string]*userRate)
+		r.lastEvicted = now
 	}
+	if now.Sub(r.lastEvicted) >= r.ttl() {
+		r.evict(now)
+	}
+
+	ur, ok := r.users[apiKey]
+	if !ok || len(ur.timestamps) != limit {
+		// Add new API key to rate limiter, or restart it if its limit changed
+		ur = newUserRate(limit)
+		r.users[apiKey] = ur
+	}
+	return ur.allow(now, r.window())
+}
+
+// evict removes API keys that have not been used within the TTL.
+func (r *RateLimiter) evict(now time.Time) {
+	for apiKey, ur := range r.users {
+		if now.Sub(ur.lastAccess) >= r.ttl() {
+			delete(r.users, apiKey)
+		}
+	}
+	r.lastEvicted = now
+}
+
+// Len returns the number of API keys currently tracked.
+func (r *RateLimiter) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.users)
+}
+
+func (r *RateLimiter) RateLimited(apiKey string) bool {
+	return r.Allow(context.Background(), apiKey).Limited
 }
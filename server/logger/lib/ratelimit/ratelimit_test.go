@@ -1,7 +1,11 @@
 package ratelimit
 
 import (
+	"context"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestRateLimit(t *testing.T) {
@@ -30,3 +34,82 @@ func TestRateLimit(t *testing.T) {
 		}
 	}
 }
+
+func TestRateLimitPerKey(t *testing.T) {
+	ratelimiter := RateLimiter{
+		Limit: 2,
+		GetLimit: func(apiKey string) (int, bool) {
+			return 3, apiKey == "premium"
+		},
+	}
+
+	for apiKey, limit := range map[string]int{"free": 2, "premium": 3} {
+		for i := 0; i < limit+1; i++ {
+			result := ratelimiter.Allow(context.Background(), apiKey)
+			if expected := i >= limit; result.Limited != expected {
+				t.Errorf("%s %d: got %t, expected %t", apiKey, i, result.Limited, expected)
+			}
+			if expected := max(limit-i-1, 0); result.Remaining != expected {
+				t.Errorf("%s %d: got %d remaining, expected %d", apiKey, i, result.Remaining, expected)
+			}
+		}
+	}
+}
+
+func TestRateLimitWindow(t *testing.T) {
+	ratelimiter := RateLimiter{Limit: 1}
+	start := time.Now()
+
+	expecteds := []struct {
+		offset  time.Duration
+		limited bool
+	}{
+		{0, false},
+		{30 * time.Second, true},
+		{time.Minute, false},
+	}
+	for i, expected := range expecteds {
+		result := ratelimiter.allow("test", 1, start.Add(expected.offset))
+		if result.Limited != expected.limited {
+			t.Errorf("%d: got %t, expected %t", i, result.Limited, expected.limited)
+		}
+	}
+}
+
+func TestRateLimitEviction(t *testing.T) {
+	ratelimiter := RateLimiter{TTL: time.Minute}
+	start := time.Now()
+
+	ratelimiter.allow("test1", DefaultLimit, start)
+	ratelimiter.allow("test2", DefaultLimit, start.Add(30*time.Second))
+	if got := ratelimiter.Len(); got != 2 {
+		t.Errorf("got %d keys, expected 2", got)
+	}
+
+	// Only test1 has been idle for longer than the TTL
+	ratelimiter.allow("test3", DefaultLimit, start.Add(time.Minute))
+	if got := ratelimiter.Len(); got != 2 {
+		t.Errorf("got %d keys, expected 2", got)
+	}
+}
+
+func TestRateLimitConcurrent(t *testing.T) {
+	ratelimiter := RateLimiter{Limit: 50}
+
+	var wg sync.WaitGroup
+	var limited atomic.Int64
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ratelimiter.RateLimited("test") {
+				limited.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := limited.Load(); got != 50 {
+		t.Errorf("got %d limited, expected 50", got)
+	}
+}
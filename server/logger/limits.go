@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tom-draper/api-analytics/server/database"
+	"github.com/tom-draper/api-analytics/server/logger/lib/ratelimit"
+)
+
+// newRateLimiter creates the rate limiter for logged payloads, with limits
+// for individual API keys read from the users table.
+func newRateLimiter(pool *database.Pool) *ratelimit.RateLimiter {
+	limits := &userLimits{pool: pool, ttl: 5 * time.Minute, limits: make(map[string]userLimit)}
+	rateLimiter := &ratelimit.RateLimiter{
+		Limit:    getEnvInt("LOGGER_RATE_LIMIT", ratelimit.DefaultLimit),
+		Window:   getEnvDuration("LOGGER_RATE_LIMIT_WINDOW", ratelimit.DefaultWindow),
+		GetLimit: limits.get,
+	}
+	if os.Getenv("LOGGER_RATE_LIMIT_STORE") == "postgres" {
+		rateLimiter.Store = ratelimit.NewPostgresStore(pool)
+	}
+	return rateLimiter
+}
+
+type userLimit struct {
+	limit   int
+	ok      bool // Whether a limit is set for the API key
+	fetched time.Time
+}
+
+// userLimits caches the rate limits set for API keys in the users table.
+type userLimits struct {
+	pool *database.Pool
+	ttl  time.Duration // Time before a cached limit is fetched again
+
+	mu          sync.Mutex
+	limits      map[string]userLimit
+	lastEvicted time.Time
+}
+
+func (u *userLimits) get(apiKey string) (int, bool) {
+	now := time.Now()
+	u.mu.Lock()
+	cached, found := u.limits[apiKey]
+	u.mu.Unlock()
+	if found && now.Sub(cached.fetched) < u.ttl {
+		return cached.limit, cached.ok
+	}
+
+	limit, ok := u.fetch(apiKey)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if now.Sub(u.lastEvicted) >= u.ttl {
+		for key, cached := range u.limits {
+			if now.Sub(cached.fetched) >= u.ttl {
+				delete(u.limits, key)
+			}
+		}
+		u.lastEvicted = now
+	}
+	u.limits[apiKey] = userLimit{limit: limit, ok: ok, fetched: now}
+	return limit, ok
+}
+
+func (u *userLimits) fetch(apiKey string) (int, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	conn, err := u.pool.Acquire(ctx)
+	if err != nil {
+		return 0, false
+	}
+	defer conn.Release()
+
+	// Unknown or malformed API keys fall back to the default limit
+	var limit *int32
+	query := "SELECT rate_limit FROM users WHERE api_key = $1;"
+	err = conn.QueryRow(ctx, query, apiKey).Scan(&limit)
+	if err != nil || limit == nil {
+		return 0, false
+	}
+	return int(*limit), true
+}
@@ -4,17 +4,22 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"net"
+	"math"
 	"net/http"
-	"strings"
+	"net/netip"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
 
 	"github.com/tom-draper/api-analytics/server/database"
+	"github.com/tom-draper/api-analytics/server/logger/lib/geo"
 	"github.com/tom-draper/api-analytics/server/logger/lib/log"
 	"github.com/tom-draper/api-analytics/server/logger/lib/ratelimit"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/oschwald/geoip2-golang"
 )
 
 func main() {
@@ -25,23 +30,71 @@ func main() {
 
 	app.Use(cors.Default())
 
-	handler := logRequestHandler()
+	pool, err := database.NewPool(context.Background(), database.NewPoolConfig())
+	if err != nil {
+		panic(err)
+	}
+	defer pool.Close()
+
+	locator := geo.NewLocator(geo.NewConfig())
+	defer locator.Close()
+
+	dedupe := newDedupeWindow(getEnvDuration("LOGGER_DEDUPE_WINDOW", defaultDedupeWindow))
+	q := newQueue(pool, locator, dedupe, NewQueueConfig())
+
+	rateLimiter := newRateLimiter(pool)
+	tokens := newTokenKeys(pool)
+	handler := logRequestHandler(q, rateLimiter, dedupe, tokens)
 	app.POST("/api/log-request", handler)
 	app.POST("/api/requests", handler)
+	app.POST("/api/v1/traces", otlpTracesHandler(q, rateLimiter, tokens))
+	app.GET("/api/health", healthHandler(pool))
+	app.GET("/api/metrics", metricsHandler(q))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go locator.Watch(ctx)
 
-	app.Run(":8000")
+	server := &http.Server{Addr: ":8000", Handler: app}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.LogToFile(err.Error())
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+	log.LogToFile("Stopping logger...")
+
+	// Stop accepting payloads, then write those already queued
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server.Shutdown(shutdownCtx)
+	q.close()
 }
 
 type RequestData struct {
-	Path         string `json:"path"`
-	Hostname     string `json:"hostname"`
-	IPAddress    string `json:"ip_address"`
-	UserAgent    string `json:"user_agent"`
-	Method       string `json:"method"`
-	Status       int16  `json:"status"`
-	ResponseTime int16  `json:"response_time"`
-	UserID       string `json:"user_id"`
-	CreatedAt    string `json:"created_at"`
+	Path               string            `json:"path"`
+	Hostname           string            `json:"hostname"`
+	IPAddress          string            `json:"ip_address"`
+	UserAgent          string            `json:"user_agent"`
+	Method             string            `json:"method"`
+	Status             int16             `json:"status"`
+	ResponseTime       int64             `json:"response_time"`    // Milliseconds, sent by all clients
+	ResponseTimeMicros int64             `json:"response_time_us"` // Microseconds, sent by newer clients
+	UserID             string            `json:"user_id"`
+	CreatedAt          string            `json:"created_at"`
+	Weight             float64           `json:"weight"` // Requests represented by this row if sampled by the client
+	RequestSize        int64             `json:"request_size"`
+	ResponseSize       int64             `json:"response_size"`
+	Protocol           string            `json:"protocol"`
+	TLS                bool              `json:"tls"`
+	Tags               map[string]string `json:"tags"`
+	ErrorClass         string            `json:"error_class"`
+	ErrorMessage       string            `json:"error_message"`
+	Sequence           int64             `json:"seq"` // Position within the client's batch
+	startTime          time.Time         // Time taken from a trace span rather than parsed from CreatedAt
 }
 
 type Payload struct {
@@ -49,6 +102,7 @@ type Payload struct {
 	Requests     []RequestData `json:"requests"`
 	Framework    string        `json:"framework"`
 	PrivacyLevel PrivacyLevel  `json:"privacy_level"`
+	BatchID      string        `json:"batch_id"` // Optional, identifies the batch across retries
 }
 
 type PrivacyLevel int
@@ -59,130 +113,109 @@ const (
 	P3                     // Client IP address never be sent to server, optional custom user ID field is the only user identification
 )
 
-func getCountryCode(IPAddress string) string {
-	if IPAddress == "" {
-		return ""
+const maxErrorMessageLength int = 256
+
+// getResponseTime returns the response time in microseconds, falling back to
+// the millisecond value sent by clients without microsecond precision.
+func getResponseTime(request RequestData) int32 {
+	responseTime := request.ResponseTimeMicros
+	if responseTime <= 0 {
+		responseTime = request.ResponseTime * 1000
 	}
-	db, err := geoip2.Open("GeoLite2-Country.mmdb")
-	if err != nil {
-		return ""
+	if responseTime < 0 {
+		return 0
+	} else if responseTime > math.MaxInt32 {
+		return math.MaxInt32
 	}
-	defer db.Close()
+	return int32(responseTime)
+}
 
-	ip := net.ParseIP(IPAddress)
-	if ip == nil {
-		return ""
+// getCreatedAt returns the time a request was made, sent in RFC 3339. Times
+// without a time zone, as sent by older clients, are read as UTC.
+func getCreatedAt(request RequestData) (time.Time, bool) {
+	if !request.startTime.IsZero() {
+		return request.startTime, true
 	}
-	record, err := db.Country(ip)
+	t, err := time.Parse(time.RFC3339Nano, request.CreatedAt)
 	if err != nil {
-		return ""
+		t, err = time.Parse("2006-01-02T15:04:05.999999999", request.CreatedAt)
 	}
-	location := record.Country.IsoCode
-	return location
+	return t, err == nil && database.ValidDate(t)
 }
 
-func storeNewUserAgents(userAgents map[string]struct{}) {
-	var query strings.Builder
-	query.WriteString("INSERT INTO user_agents (user_agent) VALUES ")
-	arguments := make([]any, len(userAgents))
-	i := 0
-	for userAgent := range userAgents {
-		query.WriteString(fmt.Sprintf("($%d)", i+1))
-		if i < len(userAgents)-1 {
-			query.WriteString(",")
-		}
-		arguments[i] = userAgent
-		i++
-	}
-	query.WriteString(" ON CONFLICT (user_agent) DO NOTHING;")
+// getErrorMessage returns the error message truncated to the maximum stored
+// length, with invalid UTF-8 and control characters removed.
+func getErrorMessage(message string) string {
+	return database.SanitizeString(message, maxErrorMessageLength)
+}
 
-	conn := database.NewConnection()
-	_, err := conn.Exec(context.Background(), query.String(), arguments...)
-	conn.Close(context.Background())
-	if err != nil {
-		log.LogToFile(err.Error())
+// getValidTags returns the tags with valid keys and values, up to the maximum
+// number of tags stored per request, or nil if none are valid.
+func getValidTags(tags map[string]string) map[string]string {
+	if len(tags) == 0 {
+		return nil
 	}
-}
+	// Sort keys so that the same tags are kept if too many are sent
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
 
-func getUserAgentIDs(userAgents map[string]struct{}) map[string]int {
-	var query strings.Builder
-	query.WriteString("SELECT user_agent, id FROM user_agents WHERE user_agent IN (")
-	arguments := make([]any, len(userAgents))
-	i := 0
-	for userAgent := range userAgents {
-		query.WriteString(fmt.Sprintf("$%d", i+1))
-		if i < len(userAgents)-1 {
-			query.WriteString(",")
+	valid := make(map[string]string)
+	for _, key := range keys {
+		if len(valid) == database.MaxTags {
+			break
+		}
+		if value := tags[key]; database.ValidTagKey(key) && database.ValidTagValue(value) {
+			valid[key] = value
 		}
-		arguments[i] = userAgent
-		i++
 	}
-	query.WriteString(");")
-
-	ids := make(map[string]int)
-	conn := database.NewConnection()
-	rows, err := conn.Query(context.Background(), query.String(), arguments...)
-	conn.Close(context.Background())
-	if err != nil {
-		log.LogToFile(err.Error())
-		return ids
+	if len(valid) == 0 {
+		return nil
 	}
-	for rows.Next() {
-		var userAgent string
-		var id int
-		err := rows.Scan(&userAgent, &id)
-		if err != nil {
+	return valid
+}
+
+func healthHandler(pool *database.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := pool.Healthy(c.Request.Context()); err != nil {
 			log.LogToFile(err.Error())
-			continue
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": http.StatusServiceUnavailable, "message": "Database unavailable."})
+			return
 		}
-		ids[userAgent] = id
+		c.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "message": "OK"})
 	}
-	return ids
 }
 
-func logRequestHandler() gin.HandlerFunc {
-	var rateLimiter = ratelimit.RateLimiter{}
-
-	const maxInsert int = 2000
-
-	var methodID = map[string]int16{
-		"GET":     0,
-		"POST":    1,
-		"PUT":     2,
-		"PATCH":   3,
-		"DELETE":  4,
-		"OPTIONS": 5,
-		"CONNECT": 6,
-		"HEAD":    7,
-		"TRACE":   8,
+func metricsHandler(q *queue) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, q.metrics())
 	}
+}
 
-	var frameworkID = map[string]int16{
-		"FastAPI":      0,
-		"Flask":        1,
-		"Gin":          2,
-		"Echo":         3,
-		"Express":      4,
-		"Fastify":      5,
-		"Koa":          6,
-		"Chi":          7,
-		"Fiber":        8,
-		"Actix":        9,
-		"Axum":         10,
-		"Tornado":      11,
-		"Django":       12,
-		"Rails":        13,
-		"Laravel":      14,
-		"Sinatra":      15,
-		"Rocket":       16,
-		"ASP.NET Core": 17,
-	}
+const maxInsert int = 2000
 
+func logRequestHandler(q *queue, rateLimiter *ratelimit.RateLimiter, dedupe *dedupeWindow, tokens *tokenKeys) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// Advertise the payload formats accepted, for clients to negotiate
+		c.Header("Accept-Post", "application/json, application/msgpack")
+		c.Header("Accept-Encoding", "gzip, zstd")
+
 		// Collect API request data sent via POST request
 		var payload Payload
-		err := c.BindJSON(&payload)
-		if err != nil {
+		err := readPayload(c.Request, &payload)
+		if err == errUnsupportedMediaType {
+			msg := "Unsupported content type or encoding."
+			log.LogErrorToFile(c.ClientIP(), "", msg)
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"status": http.StatusUnsupportedMediaType, "message": msg})
+			return
+		} else if err == errPayloadTooLarge {
+			msg := "Payload too large."
+			log.LogErrorToFile(c.ClientIP(), "", msg)
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"status": http.StatusRequestEntityTooLarge, "message": msg})
+			return
+		} else if err != nil {
 			msg := "Invalid request data."
 			log.LogErrorToFile(c.ClientIP(), "", msg)
 			c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": msg})
@@ -192,7 +225,27 @@ func logRequestHandler() gin.HandlerFunc {
 			log.LogErrorToFile(c.ClientIP(), payload.APIKey, msg)
 			c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": msg})
 			return
-		} else if rateLimiter.RateLimited(payload.APIKey) {
+		}
+
+		// Payloads sent with a token are stored under its account's API key
+		payload.APIKey, err = tokens.resolve(c.Request.Context(), payload.APIKey)
+		if err != nil {
+			status, _, msg := tokenError(err)
+			log.LogErrorToFile(c.ClientIP(), "", msg)
+			c.JSON(status, gin.H{"status": status, "message": msg})
+			return
+		}
+
+		if _, ok := parseAPIKey(payload.APIKey); !ok {
+			msg := "Invalid API key."
+			log.LogErrorToFile(c.ClientIP(), "", msg)
+			c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": msg})
+			return
+		}
+
+		rateLimit := rateLimiter.Allow(c.Request.Context(), payload.APIKey)
+		rateLimit.SetHeaders(c.Writer.Header())
+		if rateLimit.Limited {
 			msg := "Too many requests."
 			log.LogErrorToFile(c.ClientIP(), payload.APIKey, msg)
 			c.JSON(http.StatusTooManyRequests, gin.H{"status": http.StatusTooManyRequests, "message": msg})
@@ -204,168 +257,183 @@ func logRequestHandler() gin.HandlerFunc {
 			return
 		}
 
-		framework, ok := frameworkID[payload.Framework]
+		framework, ok := database.FrameworkID[payload.Framework]
 		if !ok {
 			c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Unsupported API framework."})
 			return
+		} else if len(payload.BatchID) > maxBatchIDLength || !database.ValidString(payload.BatchID) {
+			c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Invalid batch ID."})
+			return
+		} else if payload.BatchID != "" && !validSequences(payload.Requests) {
+			c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Requests in a batch require unique sequence numbers."})
+			return
 		}
 
-		var query strings.Builder
-		query.WriteString("INSERT INTO requests (api_key, path, hostname, ip_address, status, response_time, method, framework, location, user_id, created_at, user_agent_id) VALUES ")
-		arguments := make([]any, 0)
-		inserted := 0
-		userAgents := make([]string, 0)
-		uniqueUserAgents := map[string]struct{}{}
-		badUserAgents := map[string]struct{}{}
-		for _, request := range payload.Requests {
-			// Temporary request per minute limit
-			if inserted >= maxInsert {
-				break
-			}
+		b, response := newBatch(payload, framework)
 
-			var location string
-			if payload.PrivacyLevel < P3 {
-				// Location inferred from IP and stored for privacy level P1 and P2
-				location = getCountryCode(request.IPAddress)
-			}
-
-			if payload.PrivacyLevel > P1 {
-				// Client IP address discarded for privacy level P2 and P3
-				request.IPAddress = ""
-			}
-			var ipAddress any
-			if request.IPAddress == "" {
-				ipAddress = nil
-			} else {
-				ipAddress = request.IPAddress
-			}
-
-			method, ok := methodID[request.Method]
+		// Discard rows already written if the client is retrying the batch
+		if payload.BatchID != "" {
+			duplicates, ok := dedupe.claim(&b, payload.BatchID)
 			if !ok {
-				continue
+				msg := "Requests from this batch are still being written."
+				log.LogErrorToFile(c.ClientIP(), payload.APIKey, msg)
+				c.Header("Retry-After", "5")
+				c.JSON(http.StatusServiceUnavailable, gin.H{"status": http.StatusServiceUnavailable, "message": msg})
+				return
 			}
-
-			if len(request.UserAgent) > 255 {
-				request.UserAgent = request.UserAgent[:255]
-			}
-			if !database.ValidUserAgent(request.UserAgent) {
-				// Store bad user agent for logging
-				if _, ok := badUserAgents[request.UserAgent]; !ok {
-					badUserAgents[request.UserAgent] = struct{}{}
-				}
-				continue
+			response.Duplicates = duplicates
+			response.Accepted = len(b.rows)
+			if len(b.rows) == 0 && response.Duplicates > 0 {
+				response.Status = http.StatusAccepted
+				response.Message = "API requests already accepted."
+				c.JSON(http.StatusAccepted, response)
+				return
 			}
+		}
 
-			if len(request.UserID) > 255 {
-				request.UserID = request.UserID[:255]
-			}
-			if !database.ValidUserID(request.UserID) {
-				continue
-			}
+		// If no valid logged requests received
+		if len(b.rows) == 0 {
+			log.LogToFile("No rows inserted.")
+			response.Status = http.StatusBadRequest
+			response.Message = "Invalid request data."
+			c.JSON(http.StatusBadRequest, response)
+			return
+		}
 
-			if len(request.Hostname) > 255 {
-				request.Hostname = request.Hostname[:255]
-			}
-			if !database.ValidHostname(request.Hostname) {
-				continue
+		// Rows are written to the database in the background
+		if !q.enqueue(b) {
+			if b.batchID != "" {
+				dedupe.release(b, b.batchID)
 			}
+			msg := "Too many requests queued."
+			log.LogErrorToFile(c.ClientIP(), payload.APIKey, msg)
+			c.Header("Retry-After", "5")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": http.StatusServiceUnavailable, "message": msg})
+			return
+		}
 
-			if len(request.Path) > 255 {
-				request.Path = request.Path[:255]
-			}
-			if !database.ValidPath(request.Path) {
-				continue
-			}
+		// Return success response once queued, so rows that later fail to be
+		// written are dropped rather than sent again (at most once delivery)
+		response.Status = http.StatusAccepted
+		response.Message = "API requests accepted."
+		c.JSON(http.StatusAccepted, response)
 
-			// If not at final row in query, separate with comma
-			if inserted > 0 && inserted < maxInsert && inserted < len(payload.Requests) {
-				query.WriteString(",")
-			}
+		// Record in log file for debugging
+		log.LogRequestsToFile(payload.APIKey, len(b.rows), len(payload.Requests))
+	}
+}
 
-			// Register user agent to be stored in the database
-			if _, ok := uniqueUserAgents[request.UserAgent]; !ok {
-				uniqueUserAgents[request.UserAgent] = struct{}{}
-			}
-			// Temp store for user agents in each row for conversion to user agent IDs
-			userAgents = append(userAgents, request.UserAgent)
-
-			numArgs := len(arguments)
-			query.WriteString(
-				fmt.Sprintf("($%d,$%d,$%d,$%d,$%d,$%d,$%d,$%d,$%d,$%d,$%d,$%d)",
-					numArgs+1,
-					numArgs+2,
-					numArgs+3,
-					numArgs+4,
-					numArgs+5,
-					numArgs+6,
-					numArgs+7,
-					numArgs+8,
-					numArgs+9,
-					numArgs+10,
-					numArgs+11,
-					numArgs+12),
-			)
-			arguments = append(
-				arguments,
-				payload.APIKey,
-				request.Path,
-				request.Hostname,
-				ipAddress,
-				request.Status,
-				request.ResponseTime,
-				method,
-				framework,
-				location,
-				request.UserID,
-				request.CreatedAt,
-				0)
-			inserted += 1
+// newBatch validates the logged requests in a payload, returning the valid
+// rows and the reasons any requests were rejected.
+func newBatch(payload Payload, framework int16) (batch, *IngestResponse) {
+	b := batch{apiKey: payload.APIKey, framework: framework, batchID: payload.BatchID}
+	response := newIngestResponse()
+	badUserAgents := map[string]struct{}{}
+	for i, request := range payload.Requests {
+		// Temporary request per minute limit
+		if len(b.rows) >= maxInsert {
+			response.reject(rejectOverLimit, i)
+			continue
 		}
 
-		// If no valid logged requests received
-		if inserted == 0 {
-			log.LogToFile("No rows inserted.")
-			c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Invalid request data."})
-			return
+		var lookupIP string
+		if payload.PrivacyLevel < P3 {
+			// Location inferred from IP and stored for privacy level P1 and P2
+			lookupIP = request.IPAddress
 		}
 
-		query.WriteString(";")
+		if payload.PrivacyLevel > P1 {
+			// Client IP address discarded for privacy level P2 and P3
+			request.IPAddress = ""
+		}
+		var ipAddress any
+		if addr, err := netip.ParseAddr(request.IPAddress); err == nil {
+			ipAddress = addr.WithZone("")
+		}
 
-		// Store any new user agents found
-		storeNewUserAgents(uniqueUserAgents)
-		// Get associated user IDs for user agents
-		userAgentIDs := getUserAgentIDs(uniqueUserAgents)
-		// Insert user agent IDs into arguments
-		for i, userAgent := range userAgents {
-			if id, ok := userAgentIDs[userAgent]; ok {
-				arguments[(i*12)+11] = id
+		fields := database.RequestFields{Method: request.Method, UserAgent: request.UserAgent, UserID: request.UserID, Hostname: request.Hostname, Path: request.Path}
+		method, reason := database.ValidateRequestFields(&fields)
+		if reason != "" {
+			if reason == rejectInvalidUserAgent {
+				// Store bad user agent for logging
+				badUserAgents[fields.UserAgent] = struct{}{}
 			}
+			response.reject(reason, i)
+			continue
 		}
+		request.UserAgent, request.UserID, request.Hostname, request.Path = fields.UserAgent, fields.UserID, fields.Hostname, fields.Path
 
-		// Insert logged requests into database
-		conn := database.NewConnection()
-		_, err = conn.Exec(context.Background(), query.String(), arguments...)
-		conn.Close(context.Background())
-		if err != nil {
-			log.LogToFile(err.Error())
-			c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Invalid data."})
-			return
+		createdAt, ok := getCreatedAt(request)
+		if !ok {
+			response.reject(rejectInvalidDate, i)
+			continue
 		}
 
-		// Return success response
-		c.JSON(http.StatusCreated, gin.H{"status": http.StatusCreated, "message": "API requests logged successfully."})
+		// Rows without a valid sample weight represent a single request
+		if request.Weight <= 0 {
+			request.Weight = 1
+		}
 
-		// Record in log file for debugging
-		log.LogRequestsToFile(payload.APIKey, inserted, len(payload.Requests))
-		// Log any bad user agents found
-		if len(badUserAgents) > 0 {
-			var msg bytes.Buffer
-			index := 0
-			for userAgent := range badUserAgents {
-				msg.WriteString(fmt.Sprintf("[%d] bad user agent: %s\n", index, userAgent))
-				index++
+		// Body sizes are unknown if negative, protocol unknown if not recognised
+		var requestSize, responseSize, protocol any
+		if request.RequestSize >= 0 {
+			requestSize = request.RequestSize
+		}
+		if request.ResponseSize >= 0 {
+			responseSize = request.ResponseSize
+		}
+		if id, ok := database.ProtocolID[request.Protocol]; ok {
+			protocol = id
+		}
+
+		var tags any
+		if valid := getValidTags(request.Tags); valid != nil {
+			tags = valid
+		}
+
+		// Error details only stored for recognised error classes
+		var errorClass, errorMessage any
+		if id, ok := database.ErrorClassID[request.ErrorClass]; ok {
+			errorClass = id
+			if message := getErrorMessage(request.ErrorMessage); message != "" {
+				errorMessage = message
 			}
-			log.LogToFile(msg.String())
 		}
+
+		b.rows = append(b.rows, row{
+			path:         request.Path,
+			hostname:     request.Hostname,
+			ipAddress:    ipAddress,
+			lookupIP:     lookupIP,
+			status:       request.Status,
+			responseTime: getResponseTime(request),
+			method:       method,
+			userID:       request.UserID,
+			createdAt:    createdAt,
+			weight:       float32(request.Weight),
+			requestSize:  requestSize,
+			responseSize: responseSize,
+			protocol:     protocol,
+			tls:          request.TLS,
+			tags:         tags,
+			errorClass:   errorClass,
+			errorMessage: errorMessage,
+			userAgent:    request.UserAgent,
+			sequence:     request.Sequence,
+		})
+	}
+
+	response.Accepted = len(b.rows)
+
+	// Log any bad user agents found
+	if len(badUserAgents) > 0 {
+		var msg bytes.Buffer
+		index := 0
+		for userAgent := range badUserAgents {
+			msg.WriteString(fmt.Sprintf("[%d] bad user agent: %s\n", index, userAgent))
+			index++
+		}
+		log.LogToFile(msg.String())
 	}
+	return b, response
 }
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tom-draper/api-analytics/server/database"
+	"github.com/tom-draper/api-analytics/server/logger/lib/log"
+	"github.com/tom-draper/api-analytics/server/logger/lib/ratelimit"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Framework recorded for requests ingested from OpenTelemetry spans
+const otlpFramework string = "OpenTelemetry"
+
+const (
+	otlpSpanKindServer  int = 2
+	otlpStatusCodeError int = 2
+)
+
+// gRPC status codes returned in OTLP error responses
+const (
+	rpcInvalidArgument   int = 3
+	rpcPermissionDenied  int = 7
+	rpcResourceExhausted int = 8
+	rpcUnavailable       int = 14
+	rpcUnauthenticated   int = 16
+)
+
+// otlpSpan holds the fields of an OTLP span used to record a request.
+type otlpSpan struct {
+	kind          int
+	start         uint64 // Unix nanoseconds
+	end           uint64
+	attributes    map[string]any // Values are string, int64, float64 or bool
+	statusCode    int
+	statusMessage string
+}
+
+// otlpTracesHandler ingests OTLP/HTTP trace exports, recording each HTTP
+// server span as a logged request. The API key, or a token with the ingest
+// scope, is read from the X-AUTH-TOKEN header and the privacy level from the optional X-Privacy-Level header.
+func otlpTracesHandler(q *queue, rateLimiter *ratelimit.RateLimiter, tokens *tokenKeys) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		protobuf := true
+		if mediaType, _, err := mime.ParseMediaType(c.GetHeader("Content-Type")); err == nil && mediaType == "application/json" {
+			protobuf = false
+		}
+
+		apiKey := c.GetHeader("X-AUTH-TOKEN")
+		if apiKey == "" {
+			msg := "API key requied."
+			log.LogErrorToFile(c.ClientIP(), apiKey, msg)
+			writeOTLPStatus(c, protobuf, http.StatusUnauthorized, rpcUnauthenticated, msg)
+			return
+		}
+
+		apiKey, err := tokens.resolve(c.Request.Context(), apiKey)
+		if err != nil {
+			status, code, msg := tokenError(err)
+			log.LogErrorToFile(c.ClientIP(), "", msg)
+			writeOTLPStatus(c, protobuf, status, code, msg)
+			return
+		}
+
+		if _, ok := parseAPIKey(apiKey); !ok {
+			msg := "Invalid API key."
+			log.LogErrorToFile(c.ClientIP(), "", msg)
+			writeOTLPStatus(c, protobuf, http.StatusUnauthorized, rpcUnauthenticated, msg)
+			return
+		}
+
+		rateLimit := rateLimiter.Allow(c.Request.Context(), apiKey)
+		rateLimit.SetHeaders(c.Writer.Header())
+		if rateLimit.Limited {
+			msg := "Too many requests."
+			log.LogErrorToFile(c.ClientIP(), apiKey, msg)
+			writeOTLPStatus(c, protobuf, http.StatusTooManyRequests, rpcResourceExhausted, msg)
+			return
+		}
+
+		spans, err := readOTLPTraces(c.Request, protobuf)
+		if err == errUnsupportedMediaType {
+			msg := "Unsupported content type or encoding."
+			log.LogErrorToFile(c.ClientIP(), apiKey, msg)
+			writeOTLPStatus(c, protobuf, http.StatusUnsupportedMediaType, rpcInvalidArgument, msg)
+			return
+		} else if err != nil {
+			msg := "Invalid trace data."
+			log.LogErrorToFile(c.ClientIP(), apiKey, msg)
+			writeOTLPStatus(c, protobuf, http.StatusBadRequest, rpcInvalidArgument, msg)
+			return
+		}
+
+		// Only server spans describe requests handled by the API
+		requests := make([]RequestData, 0, len(spans))
+		for _, span := range spans {
+			if span.kind == otlpSpanKindServer {
+				requests = append(requests, getSpanRequestData(span))
+			}
+		}
+		if len(requests) == 0 {
+			writeOTLPResponse(c, protobuf, 0, "")
+			return
+		}
+
+		payload := Payload{
+			APIKey:       apiKey,
+			Requests:     requests,
+			Framework:    otlpFramework,
+			PrivacyLevel: getPrivacyLevel(c.GetHeader("X-Privacy-Level")),
+		}
+		b, response := newBatch(payload, database.FrameworkID[otlpFramework])
+		if len(b.rows) > 0 && !q.enqueue(b) {
+			msg := "Too many requests queued."
+			log.LogErrorToFile(c.ClientIP(), apiKey, msg)
+			c.Header("Retry-After", "5")
+			writeOTLPStatus(c, protobuf, http.StatusServiceUnavailable, rpcUnavailable, msg)
+			return
+		}
+
+		writeOTLPResponse(c, protobuf, response.Rejected, getRejectionSummary(response))
+		log.LogRequestsToFile(apiKey, len(b.rows), len(payload.Requests))
+	}
+}
+
+func getPrivacyLevel(value string) PrivacyLevel {
+	level, err := strconv.Atoi(value)
+	if err != nil || level < int(P1) || level > int(P3) {
+		return P1
+	}
+	return PrivacyLevel(level)
+}
+
+// getSpanRequestData maps the HTTP semantic convention attributes of a server
+// span onto a logged request, falling back to deprecated attribute names.
+func getSpanRequestData(span otlpSpan) RequestData {
+	path := getStringAttribute(span.attributes, "http.route", "url.path", "http.target")
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+
+	var duration uint64
+	if span.end > span.start {
+		duration = span.end - span.start
+	}
+
+	request := RequestData{
+		Path:               path,
+		Hostname:           getStringAttribute(span.attributes, "server.address", "http.host", "net.host.name"),
+		IPAddress:          getStringAttribute(span.attributes, "client.address", "http.client_ip", "net.sock.peer.addr"),
+		UserAgent:          getStringAttribute(span.attributes, "user_agent.original", "http.user_agent"),
+		Method:             strings.ToUpper(getStringAttribute(span.attributes, "http.request.method", "http.method")),
+		Status:             int16(getIntAttribute(span.attributes, "http.response.status_code", "http.status_code")),
+		ResponseTimeMicros: int64(duration / uint64(time.Microsecond)),
+		ResponseTime:       int64(duration / uint64(time.Millisecond)),
+		RequestSize:        -1,
+		ResponseSize:       -1,
+		Protocol:           getSpanProtocol(getStringAttribute(span.attributes, "network.protocol.version", "http.flavor")),
+		TLS:                getStringAttribute(span.attributes, "url.scheme", "http.scheme") == "https",
+	}
+	// Spans without a start time are left without a created_at, so they are
+	// rejected as invalid_date rather than recorded in 1970
+	if span.start > 0 {
+		request.startTime = time.Unix(0, int64(span.start)).UTC()
+	}
+	if size, ok := span.attributes["http.request.body.size"].(int64); ok {
+		request.RequestSize = size
+	}
+	if size, ok := span.attributes["http.response.body.size"].(int64); ok {
+		request.ResponseSize = size
+	}
+	if span.statusCode == otlpStatusCodeError {
+		request.ErrorClass = "handler_error"
+		request.ErrorMessage = span.statusMessage
+	}
+	return request
+}
+
+func getSpanProtocol(version string) string {
+	switch version {
+	case "1.0", "1.1":
+		return "HTTP/" + version
+	case "2", "2.0":
+		return "HTTP/2.0"
+	case "3", "3.0":
+		return "HTTP/3.0"
+	}
+	return ""
+}
+
+func getStringAttribute(attributes map[string]any, keys ...string) string {
+	for _, key := range keys {
+		if value, ok := attributes[key].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func getIntAttribute(attributes map[string]any, keys ...string) int64 {
+	for _, key := range keys {
+		if value, ok := attributes[key].(int64); ok {
+			return value
+		}
+	}
+	return 0
+}
+
+// getRejectionSummary describes the reasons spans were rejected, for the
+// error message of a partial success response.
+func getRejectionSummary(response *IngestResponse) string {
+	if response.Rejected == 0 {
+		return ""
+	}
+	reasons := make([]string, 0, len(response.Rejections))
+	for reason, rejection := range response.Rejections {
+		reasons = append(reasons, fmt.Sprintf("%s %d", reason, rejection.Count))
+	}
+	sort.Strings(reasons)
+	return fmt.Sprintf("%d spans rejected: %s", response.Rejected, strings.Join(reasons, ", "))
+}
+
+// writeOTLPResponse writes an ExportTraceServiceResponse, reporting any
+// rejected spans as a partial success.
+func writeOTLPResponse(c *gin.Context, protobuf bool, rejected int, message string) {
+	if !protobuf {
+		response := gin.H{}
+		if rejected > 0 {
+			response["partialSuccess"] = gin.H{"rejectedSpans": strconv.Itoa(rejected), "errorMessage": message}
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	var body []byte
+	if rejected > 0 {
+		var partialSuccess []byte
+		partialSuccess = protowire.AppendTag(partialSuccess, 1, protowire.VarintType)
+		partialSuccess = protowire.AppendVarint(partialSuccess, uint64(rejected))
+		partialSuccess = protowire.AppendTag(partialSuccess, 2, protowire.BytesType)
+		partialSuccess = protowire.AppendString(partialSuccess, message)
+		body = protowire.AppendTag(body, 1, protowire.BytesType)
+		body = protowire.AppendBytes(body, partialSuccess)
+	}
+	c.Data(http.StatusOK, "application/x-protobuf", body)
+}
+
+// writeOTLPStatus writes an error response as a google.rpc.Status message.
+func writeOTLPStatus(c *gin.Context, protobuf bool, status int, code int, message string) {
+	if !protobuf {
+		c.JSON(status, gin.H{"code": code, "message": message})
+		return
+	}
+
+	var body []byte
+	body = protowire.AppendTag(body, 1, protowire.VarintType)
+	body = protowire.AppendVarint(body, uint64(code))
+	body = protowire.AppendTag(body, 2, protowire.BytesType)
+	body = protowire.AppendString(body, message)
+	c.Data(status, "application/x-protobuf", body)
+}
+
+// readOTLPTraces decodes the spans from an ExportTraceServiceRequest encoded
+// as protobuf or JSON.
+func readOTLPTraces(r *http.Request, protobuf bool) ([]otlpSpan, error) {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil ||
+		(mediaType != "application/x-protobuf" && mediaType != "application/protobuf" && mediaType != "application/json") {
+		return nil, errUnsupportedMediaType
+	}
+
+	body, err := decompress(r.Body, r.Header.Get("Content-Encoding"))
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, maxPayloadBytes+1))
+	if err != nil {
+		return nil, err
+	} else if int64(len(data)) > maxPayloadBytes {
+		return nil, errPayloadTooLarge
+	}
+
+	if protobuf {
+		return parseOTLPTracesProtobuf(data)
+	}
+	return parseOTLPTracesJSON(data)
+}
+
+type otlpJSONRequest struct {
+	ResourceSpans []struct {
+		ScopeSpans []struct {
+			Spans []otlpJSONSpan `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+type otlpJSONSpan struct {
+	Kind              int                `json:"kind"`
+	StartTimeUnixNano json.Number        `json:"startTimeUnixNano"` // 64-bit integers may be encoded as strings
+	EndTimeUnixNano   json.Number        `json:"endTimeUnixNano"`
+	Attributes        []otlpJSONKeyValue `json:"attributes"`
+	Status            struct {
+		Message string `json:"message"`
+		Code    int    `json:"code"`
+	} `json:"status"`
+}
+
+type otlpJSONKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue *string      `json:"stringValue"`
+		BoolValue   *bool        `json:"boolValue"`
+		IntValue    *json.Number `json:"intValue"`
+		DoubleValue *float64     `json:"doubleValue"`
+	} `json:"value"`
+}
+
+func parseOTLPTracesJSON(data []byte) ([]otlpSpan, error) {
+	var request otlpJSONRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		return nil, err
+	}
+
+	spans := make([]otlpSpan, 0)
+	for _, resourceSpans := range request.ResourceSpans {
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			for _, s := range scopeSpans.Spans {
+				start, _ := strconv.ParseUint(s.StartTimeUnixNano.String(), 10, 64)
+				end, _ := strconv.ParseUint(s.EndTimeUnixNano.String(), 10, 64)
+				span := otlpSpan{
+					kind:          s.Kind,
+					start:         start,
+					end:           end,
+					attributes:    make(map[string]any, len(s.Attributes)),
+					statusCode:    s.Status.Code,
+					statusMessage: s.Status.Message,
+				}
+				for _, attribute := range s.Attributes {
+					value := attribute.Value
+					switch {
+					case value.StringValue != nil:
+						span.attributes[attribute.Key] = *value.StringValue
+					case value.IntValue != nil:
+						if i, err := value.IntValue.Int64(); err == nil {
+							span.attributes[attribute.Key] = i
+						}
+					case value.BoolValue != nil:
+						span.attributes[attribute.Key] = *value.BoolValue
+					case value.DoubleValue != nil:
+						span.attributes[attribute.Key] = *value.DoubleValue
+					}
+				}
+				spans = append(spans, span)
+			}
+		}
+	}
+	return spans, nil
+}
+
+// consumeFields calls fn with each field of a protobuf message. Varint and
+// fixed-width values are passed as v, length-delimited values as b.
+func consumeFields(data []byte, fn func(num protowire.Number, typ protowire.Type, v uint64, b []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		var v uint64
+		var b []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(data)
+		case protowire.Fixed32Type:
+			var v32 uint32
+			v32, n = protowire.ConsumeFixed32(data)
+			v = uint64(v32)
+		case protowire.BytesType:
+			b, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if err := fn(num, typ, v, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseOTLPTracesProtobuf decodes the spans from the fields of an
+// ExportTraceServiceRequest, ResourceSpans and ScopeSpans messages.
+func parseOTLPTracesProtobuf(data []byte) ([]otlpSpan, error) {
+	spans := make([]otlpSpan, 0)
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, v uint64, resourceSpans []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		return consumeFields(resourceSpans, func(num protowire.Number, typ protowire.Type, v uint64, scopeSpans []byte) error {
+			if num != 2 || typ != protowire.BytesType {
+				return nil
+			}
+			return consumeFields(scopeSpans, func(num protowire.Number, typ protowire.Type, v uint64, b []byte) error {
+				if num != 2 || typ != protowire.BytesType {
+					return nil
+				}
+				span, err := parseOTLPSpan(b)
+				if err != nil {
+					return err
+				}
+				spans = append(spans, span)
+				return nil
+			})
+		})
+	})
+	return spans, err
+}
+
+func parseOTLPSpan(data []byte) (otlpSpan, error) {
+	span := otlpSpan{attributes: make(map[string]any)}
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, v uint64, b []byte) error {
+		switch num {
+		case 6:
+			span.kind = int(v)
+		case 7:
+			span.start = v
+		case 8:
+			span.end = v
+		case 9:
+			return parseOTLPAttribute(b, span.attributes)
+		case 15:
+			return consumeFields(b, func(num protowire.Number, typ protowire.Type, v uint64, b []byte) error {
+				switch num {
+				case 2:
+					span.statusMessage = string(b)
+				case 3:
+					span.statusCode = int(v)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return span, err
+}
+
+// parseOTLPAttribute decodes a KeyValue message holding a scalar AnyValue
+// into attributes. Array, key-value list and bytes values are ignored.
+func parseOTLPAttribute(data []byte, attributes map[string]any) error {
+	var key string
+	var value any
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, v uint64, b []byte) error {
+		switch num {
+		case 1:
+			key = string(b)
+		case 2:
+			return consumeFields(b, func(num protowire.Number, typ protowire.Type, v uint64, b []byte) error {
+				switch num {
+				case 1:
+					value = string(b)
+				case 2:
+					value = v != 0
+				case 3:
+					value = int64(v)
+				case 4:
+					value = math.Float64frombits(v)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err == nil && key != "" && value != nil {
+		attributes[key] = value
+	}
+	return err
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tom-draper/api-analytics/server/logger/lib/geo"
+	"github.com/tom-draper/api-analytics/server/logger/lib/ratelimit"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func appendMessage(b []byte, num protowire.Number, message []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, message)
+}
+
+func appendAttribute(b []byte, key string, value []byte) []byte {
+	var keyValue []byte
+	keyValue = protowire.AppendTag(keyValue, 1, protowire.BytesType)
+	keyValue = protowire.AppendString(keyValue, key)
+	keyValue = appendMessage(keyValue, 2, value)
+	return appendMessage(b, 9, keyValue)
+}
+
+func stringValue(value string) []byte {
+	b := protowire.AppendTag(nil, 1, protowire.BytesType)
+	return protowire.AppendString(b, value)
+}
+
+func intValue(value int64) []byte {
+	b := protowire.AppendTag(nil, 3, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(value))
+}
+
+func TestParseOTLPTracesProtobuf(t *testing.T) {
+	var span []byte
+	span = protowire.AppendTag(span, 6, protowire.VarintType)
+	span = protowire.AppendVarint(span, uint64(otlpSpanKindServer))
+	span = protowire.AppendTag(span, 7, protowire.Fixed64Type)
+	span = protowire.AppendFixed64(span, 1_700_000_000_000_000_000)
+	span = protowire.AppendTag(span, 8, protowire.Fixed64Type)
+	span = protowire.AppendFixed64(span, 1_700_000_000_002_500_000)
+	span = appendAttribute(span, "http.route", stringValue("/users/{id}"))
+	span = appendAttribute(span, "http.request.method", stringValue("GET"))
+	span = appendAttribute(span, "http.response.status_code", intValue(404))
+	span = appendAttribute(span, "client.address", stringValue("192.168.0.1"))
+	span = appendAttribute(span, "user_agent.original", stringValue("curl/8.0"))
+
+	scopeSpans := appendMessage(nil, 2, span)
+	resourceSpans := appendMessage(nil, 2, scopeSpans)
+	request := appendMessage(nil, 1, resourceSpans)
+
+	spans, err := parseOTLPTracesProtobuf(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, expected 1", len(spans))
+	}
+
+	data := getSpanRequestData(spans[0])
+	if data.Path != "/users/{id}" || data.Method != "GET" || data.Status != 404 || data.IPAddress != "192.168.0.1" || data.UserAgent != "curl/8.0" {
+		t.Errorf("got %+v", data)
+	}
+	if data.ResponseTimeMicros != 2500 || !data.startTime.Equal(time.Unix(1_700_000_000, 0)) {
+		t.Errorf("got response time %d and start time %s, expected 2500 and 2023-11-14T22:13:20Z", data.ResponseTimeMicros, data.startTime)
+	}
+}
+
+func TestParseOTLPTracesJSON(t *testing.T) {
+	body := `{"resourceSpans": [{"scopeSpans": [{"spans": [
+		{"kind": 2, "startTimeUnixNano": "1700000000000000000", "endTimeUnixNano": "1700000000001000000",
+		 "attributes": [
+			{"key": "http.method", "value": {"stringValue": "post"}},
+			{"key": "http.target", "value": {"stringValue": "/login?next=/"}},
+			{"key": "http.status_code", "value": {"intValue": "500"}}
+		 ],
+		 "status": {"code": 2, "message": "database timeout"}},
+		{"kind": 3, "startTimeUnixNano": "1700000000000000000", "endTimeUnixNano": "1700000000001000000"}
+	]}]}]}`
+
+	spans, err := parseOTLPTracesJSON([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spans) != 2 || spans[1].kind == otlpSpanKindServer {
+		t.Fatalf("got %+v, expected a server and a client span", spans)
+	}
+
+	data := getSpanRequestData(spans[0])
+	if data.Path != "/login" || data.Method != "POST" || data.Status != 500 || data.ResponseTime != 1 {
+		t.Errorf("got %+v", data)
+	}
+	if data.ErrorClass != "handler_error" || data.ErrorMessage != "database timeout" {
+		t.Errorf("got error %q %q, expected handler_error with message", data.ErrorClass, data.ErrorMessage)
+	}
+}
+
+func TestOTLPTracesHandler(t *testing.T) {
+	// Handler logs to requests.log in the working directory
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	// No workers, so the batch stays queued to be inspected
+	q := &queue{config: QueueConfig{MaxCopyRows: 10}, batches: make(chan batch, 1)}
+	rateLimiter := &ratelimit.RateLimiter{Limit: 10, Window: time.Minute}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/v1/traces", otlpTracesHandler(q, rateLimiter, newTokenKeys(nil)))
+
+	body := `{"resourceSpans": [{"scopeSpans": [{"spans": [
+		{"kind": 2, "startTimeUnixNano": "1700000000000000000", "endTimeUnixNano": "1700000000002500000",
+		 "attributes": [
+			{"key": "http.route", "value": {"stringValue": "/users/{id}"}},
+			{"key": "http.request.method", "value": {"stringValue": "GET"}},
+			{"key": "http.response.status_code", "value": {"intValue": "200"}},
+			{"key": "client.address", "value": {"stringValue": "192.168.0.1"}},
+			{"key": "user_agent.original", "value": {"stringValue": "curl/8.0"}}
+		 ]},
+		{"kind": 2, "endTimeUnixNano": "1700000000001000000",
+		 "attributes": [
+			{"key": "http.route", "value": {"stringValue": "/"}},
+			{"key": "http.request.method", "value": {"stringValue": "GET"}},
+			{"key": "http.response.status_code", "value": {"intValue": "200"}}
+		 ]},
+		{"kind": 3, "startTimeUnixNano": "1700000000000000000", "endTimeUnixNano": "1700000000001000000"}
+	]}]}]}`
+	request := httptest.NewRequest(http.MethodPost, "/api/v1/traces", strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-AUTH-TOKEN", "b56cbd92-1168-4d7b-8d94-0418da207908")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, expected 200 (%s)", recorder.Code, recorder.Body.String())
+	}
+	// Server span without a start time rejected
+	if !strings.Contains(recorder.Body.String(), `"rejectedSpans":"1"`) || !strings.Contains(recorder.Body.String(), "invalid_date 1") {
+		t.Errorf("got response %s, expected 1 span rejected as invalid_date", recorder.Body.String())
+	}
+
+	var b batch
+	select {
+	case b = <-q.batches:
+	default:
+		t.Fatal("got no batch queued")
+	}
+	rows := copyRows([]batch{b}, [][]geo.Location{make([]geo.Location, len(b.rows))}, map[string]int{"curl/8.0": 1})
+	if len(rows) != 1 {
+		t.Fatalf("got %d rows, expected 1", len(rows))
+	}
+	checkCopyRowsEncode(t, rows)
+
+	for i, column := range requestColumns {
+		if column == "created_at" {
+			if createdAt, ok := rows[0][i].(time.Time); !ok || !createdAt.Equal(time.Unix(1_700_000_000, 0)) {
+				t.Errorf("got created at %v, expected 2023-11-14T22:13:20Z", rows[0][i])
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Maximum size of a payload after decompression
+const maxPayloadBytes int64 = 64 << 20
+
+// errUnsupportedMediaType is returned for payloads with an encoding or
+// content type the logger cannot read.
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+// errPayloadTooLarge is returned for payloads exceeding the maximum size once
+// decompressed.
+var errPayloadTooLarge = errors.New("payload too large")
+
+// readPayload decodes a payload from the request body, decompressing it
+// according to Content-Encoding and decoding it as JSON or MessagePack
+// according to Content-Type.
+func readPayload(r *http.Request, payload *Payload) error {
+	body, err := decompress(r.Body, r.Header.Get("Content-Encoding"))
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	limited := &io.LimitedReader{R: body, N: maxPayloadBytes + 1}
+
+	mediaType := "application/json"
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return errUnsupportedMediaType
+		}
+	}
+
+	switch mediaType {
+	case "application/json", "text/plain":
+		err = json.NewDecoder(limited).Decode(payload)
+	case "application/msgpack", "application/x-msgpack", "application/vnd.msgpack":
+		decoder := msgpack.NewDecoder(limited)
+		decoder.SetCustomStructTag("json")
+		err = decoder.Decode(payload)
+	default:
+		return errUnsupportedMediaType
+	}
+	if limited.N <= 0 {
+		return errPayloadTooLarge
+	}
+	return err
+}
+
+func decompress(body io.ReadCloser, contentEncoding string) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(body)
+	case "zstd":
+		decoder, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(maxPayloadBytes)))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return nil, errUnsupportedMediaType
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestReadPayload(t *testing.T) {
+	// Encoded as sent by clients, with a 64-bit weight
+	sent := map[string]any{
+		"api_key":   "test",
+		"framework": "Gin",
+		"requests": []map[string]any{
+			{"path": "/", "method": "GET", "status": 200, "weight": 2.5, "tags": map[string]string{"region": "eu-west"}},
+		},
+	}
+	jsonBody, _ := json.Marshal(sent)
+	msgpackBody, _ := msgpack.Marshal(sent)
+
+	var gzipBody bytes.Buffer
+	gzw := gzip.NewWriter(&gzipBody)
+	gzw.Write(msgpackBody)
+	gzw.Close()
+
+	zstdEncoder, _ := zstd.NewWriter(nil)
+	zstdBody := zstdEncoder.EncodeAll(jsonBody, nil)
+
+	tests := []struct {
+		body            []byte
+		contentType     string
+		contentEncoding string
+		err             error
+	}{
+		{jsonBody, "", "", nil},
+		{jsonBody, "application/json; charset=utf-8", "", nil},
+		{msgpackBody, "application/msgpack", "", nil},
+		{gzipBody.Bytes(), "application/x-msgpack", "gzip", nil},
+		{zstdBody, "application/json", "zstd", nil},
+		{jsonBody, "application/xml", "", errUnsupportedMediaType},
+		{jsonBody, "application/json", "br", errUnsupportedMediaType},
+	}
+
+	for i, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/api/log-request", bytes.NewReader(test.body))
+		if test.contentType != "" {
+			r.Header.Set("Content-Type", test.contentType)
+		}
+		if test.contentEncoding != "" {
+			r.Header.Set("Content-Encoding", test.contentEncoding)
+		}
+
+		var payload Payload
+		err := readPayload(r, &payload)
+		if err != test.err {
+			t.Errorf("%d: got error %v, expected %v", i, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if payload.APIKey != "test" || len(payload.Requests) != 1 {
+			t.Errorf("%d: got %+v", i, payload)
+			continue
+		}
+		request := payload.Requests[0]
+		if request.Status != 200 || request.Weight != 2.5 || request.Tags["region"] != "eu-west" {
+			t.Errorf("%d: got %+v", i, request)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/tom-draper/api-analytics/server/database"
+	"github.com/tom-draper/api-analytics/server/logger/lib/geo"
+	"github.com/tom-draper/api-analytics/server/logger/lib/log"
+)
+
+// Columns written to the requests table for each row
+var requestColumns = []string{"api_key", "path", "hostname", "ip_address", "status", "response_time", "method", "framework", "location", "user_id", "created_at", "weight", "request_size", "response_size", "protocol", "tls", "tags", "error_class", "error_message", "region", "city", "asn", "isp", "user_agent_id"}
+
+// row is a validated logged request waiting to be written. Location and user
+// agent ID are resolved by the workers.
+type row struct {
+	path         string
+	hostname     string
+	ipAddress    any    // netip.Addr, or nil if discarded for privacy
+	lookupIP     string // IP address to infer location from, empty if not inferred
+	status       int16
+	responseTime int32
+	method       int16
+	userID       string
+	createdAt    time.Time
+	weight       float32
+	requestSize  any
+	responseSize any
+	protocol     any
+	tls          bool
+	tags         any
+	errorClass   any
+	errorMessage any
+	userAgent    string
+	sequence     int64 // Position within the client's batch, for deduplication
+}
+
+// batch holds the valid rows from a single payload.
+type batch struct {
+	apiKey    string
+	framework int16
+	batchID   string // Client batch ID the rows were claimed under, if any
+	rows      []row
+}
+
+type QueueConfig struct {
+	Size         int           // Maximum number of payloads waiting to be written
+	Workers      int           // Number of concurrent database writers
+	MaxCopyRows  int           // Rows from multiple payloads merged into a single COPY
+	MaxRetries   int           // Attempts made while the database is unavailable
+	RetryBackoff time.Duration // Delay before the first retry, doubled after each attempt
+}
+
+func NewQueueConfig() QueueConfig {
+	return QueueConfig{
+		Size:         getEnvInt("LOGGER_QUEUE_SIZE", 1000),
+		Workers:      getEnvInt("LOGGER_WORKERS", 4),
+		MaxCopyRows:  getEnvInt("LOGGER_MAX_COPY_ROWS", 10000),
+		MaxRetries:   3,
+		RetryBackoff: time.Second,
+	}
+}
+
+func getEnvInt(key string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil && value > 0 {
+		return value
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil && value > 0 {
+		return value
+	}
+	return fallback
+}
+
+// QueueMetrics reports the state of the ingestion queue, for monitoring
+// backpressure.
+type QueueMetrics struct {
+	Length        int    `json:"queue_length"`
+	Capacity      int    `json:"queue_capacity"`
+	Workers       int    `json:"workers"`
+	BusyWorkers   int64  `json:"busy_workers"`
+	Enqueued      uint64 `json:"enqueued_total"`       // Payloads accepted
+	Rejected      uint64 `json:"rejected_total"`       // Payloads shed because the queue was full
+	RowsWritten   uint64 `json:"rows_written_total"`   // Rows copied into the database
+	BatchesFailed uint64 `json:"batches_failed_total"` // Payloads dropped after failing to be written
+}
+
+// queue buffers validated payloads for a pool of workers that write them to
+// the database in bulk.
+type queue struct {
+	pool    *database.Pool
+	locator *geo.Locator
+	dedupe  *dedupeWindow
+	config  QueueConfig
+	batches chan batch
+	wg      sync.WaitGroup
+
+	busy          atomic.Int64
+	enqueued      atomic.Uint64
+	rejected      atomic.Uint64
+	rowsWritten   atomic.Uint64
+	batchesFailed atomic.Uint64
+}
+
+func newQueue(pool *database.Pool, locator *geo.Locator, dedupe *dedupeWindow, config QueueConfig) *queue {
+	q := &queue{
+		pool:    pool,
+		locator: locator,
+		dedupe:  dedupe,
+		config:  config,
+		batches: make(chan batch, config.Size),
+	}
+	for i := 0; i < config.Workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+// enqueue adds a batch to the queue without blocking, returning false if the
+// queue is full.
+func (q *queue) enqueue(b batch) bool {
+	select {
+	case q.batches <- b:
+		q.enqueued.Add(1)
+		return true
+	default:
+		q.rejected.Add(1)
+		return false
+	}
+}
+
+// close stops accepting batches and waits for the workers to write those
+// already queued.
+func (q *queue) close() {
+	close(q.batches)
+	q.wg.Wait()
+}
+
+func (q *queue) metrics() QueueMetrics {
+	return QueueMetrics{
+		Length:        len(q.batches),
+		Capacity:      cap(q.batches),
+		Workers:       q.config.Workers,
+		BusyWorkers:   q.busy.Load(),
+		Enqueued:      q.enqueued.Load(),
+		Rejected:      q.rejected.Load(),
+		RowsWritten:   q.rowsWritten.Load(),
+		BatchesFailed: q.batchesFailed.Load(),
+	}
+}
+
+func (q *queue) work() {
+	defer q.wg.Done()
+	for b := range q.batches {
+		q.busy.Add(1)
+		q.write(q.collect(b))
+		q.busy.Add(-1)
+	}
+}
+
+// collect merges any other waiting batches with b, up to the maximum number
+// of rows in a single COPY.
+func (q *queue) collect(b batch) []batch {
+	batches := []batch{b}
+	n := len(b.rows)
+	for n < q.config.MaxCopyRows {
+		select {
+		case next, ok := <-q.batches:
+			if !ok {
+				return batches
+			}
+			batches = append(batches, next)
+			n += len(next.rows)
+		default:
+			return batches
+		}
+	}
+	return batches
+}
+
+func (q *queue) write(batches []batch) {
+	locations := make([][]geo.Location, len(batches))
+	for i, b := range batches {
+		locations[i] = make([]geo.Location, len(b.rows))
+		for j, r := range b.rows {
+			if r.lookupIP != "" {
+				locations[i][j] = q.locator.Lookup(r.lookupIP)
+			}
+		}
+	}
+
+	err := q.writeWithRetry(batches, locations)
+	if err == nil || database.IsUnavailable(err) || len(batches) == 1 {
+		q.recordResult(batches, err)
+		return
+	}
+
+	// Write payloads separately so that invalid data only drops its own rows
+	for i := range batches {
+		err := q.writeWithRetry(batches[i:i+1], locations[i:i+1])
+		q.recordResult(batches[i:i+1], err)
+	}
+}
+
+func (q *queue) recordResult(batches []batch, err error) {
+	if err != nil {
+		q.batchesFailed.Add(uint64(len(batches)))
+		for _, b := range batches {
+			log.LogErrorToFile("", b.apiKey, fmt.Sprintf("Failed to write %d rows - %s", len(b.rows), err.Error()))
+			// Accept the rows if the client sends them again rather than
+			// acknowledging them as duplicates
+			if b.batchID != "" && q.dedupe != nil {
+				q.dedupe.release(b, b.batchID)
+			}
+		}
+		return
+	}
+	for _, b := range batches {
+		q.rowsWritten.Add(uint64(len(b.rows)))
+		if b.batchID != "" && q.dedupe != nil {
+			q.dedupe.commit(b, b.batchID)
+		}
+	}
+}
+
+// writeWithRetry copies the batches into the database, retrying with backoff
+// while the database is unavailable.
+func (q *queue) writeWithRetry(batches []batch, locations [][]geo.Location) error {
+	backoff := q.config.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := q.copy(batches, locations)
+		if err == nil || !database.IsUnavailable(err) || attempt >= q.config.MaxRetries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (q *queue) copy(batches []batch, locations [][]geo.Location) error {
+	ctx := context.Background()
+	conn, err := q.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	// Store any new user agents found and get their IDs
+	uniqueUserAgents := map[string]struct{}{}
+	for _, b := range batches {
+		for _, r := range b.rows {
+			uniqueUserAgents[r.userAgent] = struct{}{}
+		}
+	}
+	userAgentIDs, err := database.StoreUserAgents(ctx, conn.Conn(), uniqueUserAgents)
+	if err != nil {
+		return err
+	}
+
+	rows := copyRows(batches, locations, userAgentIDs)
+	_, err = conn.Conn().CopyFrom(ctx, pgx.Identifier{"requests"}, requestColumns, pgx.CopyFromRows(rows))
+	return err
+}
+
+// copyRows returns the values of each row in the order of the request
+// columns.
+func copyRows(batches []batch, locations [][]geo.Location, userAgentIDs map[string]int) [][]any {
+	rows := make([][]any, 0)
+	for i, b := range batches {
+		// Validated by the handler before the batch was queued
+		apiKey, _ := parseAPIKey(b.apiKey)
+		for j, r := range b.rows {
+			location := locations[i][j]
+			rows = append(rows, []any{
+				apiKey,
+				r.path,
+				r.hostname,
+				r.ipAddress,
+				r.status,
+				r.responseTime,
+				r.method,
+				b.framework,
+				location.Country,
+				r.userID,
+				r.createdAt,
+				r.weight,
+				r.requestSize,
+				r.responseSize,
+				r.protocol,
+				r.tls,
+				r.tags,
+				r.errorClass,
+				r.errorMessage,
+				nullString(location.Region),
+				nullString(location.City),
+				nullASN(location.ASN),
+				nullString(location.ISP),
+				userAgentIDs[r.userAgent],
+			})
+		}
+	}
+
+	return rows
+}
+
+// parseAPIKey parses an API key as the UUID it is stored as. COPY sends
+// values in binary, so columns are not converted from text by Postgres.
+func parseAPIKey(apiKey string) (pgtype.UUID, bool) {
+	var uuid pgtype.UUID
+	err := uuid.Scan(apiKey)
+	return uuid, err == nil
+}
+
+func nullString(value string) any {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+func nullASN(asn uint) any {
+	if asn == 0 {
+		return nil
+	}
+	return int64(asn)
+}
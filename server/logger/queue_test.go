@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/tom-draper/api-analytics/server/database"
+	"github.com/tom-draper/api-analytics/server/logger/lib/geo"
+)
+
+func TestQueueSheds(t *testing.T) {
+	// No workers, so batches stay queued
+	q := &queue{config: QueueConfig{MaxCopyRows: 3}, batches: make(chan batch, 2)}
+
+	for i := 0; i < 3; i++ {
+		accepted := q.enqueue(batch{apiKey: "key", rows: make([]row, 2)})
+		if expected := i < 2; accepted != expected {
+			t.Errorf("%d: got %t, expected %t", i, accepted, expected)
+		}
+	}
+
+	metrics := q.metrics()
+	if metrics.Length != 2 || metrics.Enqueued != 2 || metrics.Rejected != 1 {
+		t.Errorf("got length %d, enqueued %d, rejected %d, expected 2, 2, 1", metrics.Length, metrics.Enqueued, metrics.Rejected)
+	}
+
+	// Both waiting batches merged as the first holds fewer than the maximum rows
+	batches := q.collect(<-q.batches)
+	if len(batches) != 2 {
+		t.Errorf("got %d batches, expected 2", len(batches))
+	}
+}
+
+// Types of the request columns, for encoding rows as COPY does
+var requestColumnOIDs = []uint32{pgtype.UUIDOID, pgtype.VarcharOID, pgtype.VarcharOID, pgtype.InetOID, pgtype.Int2OID, pgtype.Int4OID, pgtype.Int2OID, pgtype.Int2OID, pgtype.BPCharOID, pgtype.VarcharOID, pgtype.TimestamptzOID, pgtype.Float4OID, pgtype.Int8OID, pgtype.Int8OID, pgtype.Int2OID, pgtype.BoolOID, pgtype.JSONBOID, pgtype.Int2OID, pgtype.VarcharOID, pgtype.VarcharOID, pgtype.VarcharOID, pgtype.Int8OID, pgtype.VarcharOID, pgtype.Int4OID}
+
+func TestCopyRowsEncode(t *testing.T) {
+	payload := Payload{
+		APIKey:    "b56cbd92-1168-4d7b-8d94-0418da207908",
+		Framework: "Gin",
+		Requests: []RequestData{
+			{Path: "/users", Hostname: "example.com", IPAddress: "192.168.0.1", UserAgent: "curl/8.0", Method: "GET", Status: 200, ResponseTime: 12, CreatedAt: "2024-01-02T03:04:05Z", RequestSize: 10, ResponseSize: 20, Protocol: "HTTP/1.1", Tags: map[string]string{"region": "eu-west"}, ErrorClass: "timeout", ErrorMessage: "deadline exceeded"},
+			{Path: "/", UserAgent: "python-requests/2.31", Method: "POST", Status: 201, CreatedAt: "2024-01-02T03:04:05.123456", RequestSize: -1, ResponseSize: -1},
+		},
+	}
+	b, response := newBatch(payload, database.FrameworkID[payload.Framework])
+	if response.Rejected != 0 {
+		t.Fatalf("got %d rejected, expected 0", response.Rejected)
+	}
+
+	locations := [][]geo.Location{{{Country: "GB", Region: "England", ASN: 2856}, {}}}
+	rows := copyRows([]batch{b}, locations, map[string]int{"curl/8.0": 1, "python-requests/2.31": 2})
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, expected 2", len(rows))
+	}
+
+	checkCopyRowsEncode(t, rows)
+}
+
+// checkCopyRowsEncode checks each value can be encoded in binary for its
+// column, as COPY sends them.
+func checkCopyRowsEncode(t *testing.T, rows [][]any) {
+	t.Helper()
+	typeMap := pgtype.NewMap()
+	for i, values := range rows {
+		if len(values) != len(requestColumns) {
+			t.Fatalf("%d: got %d values, expected %d", i, len(values), len(requestColumns))
+		}
+		for j, value := range values {
+			if _, err := typeMap.Encode(requestColumnOIDs[j], pgtype.BinaryFormatCode, value, nil); err != nil {
+				t.Errorf("%d: %s %v (%T) - %s", i, requestColumns[j], value, value, err)
+			}
+		}
+	}
+}
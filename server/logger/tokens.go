@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tom-draper/api-analytics/server/database"
+)
+
+// Time before a token is looked up again, bounding how long a revoked token
+// can still be used to ingest
+const tokenCacheTTL time.Duration = time.Minute
+
+type tokenKey struct {
+	apiKey  string
+	err     error // Reason the token cannot be used to ingest
+	fetched time.Time
+}
+
+// tokenKeys caches the API keys of the accounts that tokens with the ingest
+// scope belong to.
+type tokenKeys struct {
+	pool *database.Pool
+	ttl  time.Duration
+
+	mu          sync.Mutex
+	keys        map[string]tokenKey // Keyed by token hash
+	lastEvicted time.Time
+}
+
+func newTokenKeys(pool *database.Pool) *tokenKeys {
+	return &tokenKeys{pool: pool, ttl: tokenCacheTTL, keys: make(map[string]tokenKey)}
+}
+
+// resolve returns the API key the payloads sent with a token are stored
+// under. API keys are returned unchanged.
+func (t *tokenKeys) resolve(ctx context.Context, credential string) (string, error) {
+	if !database.IsToken(credential) {
+		return credential, nil
+	}
+
+	hash := string(database.HashToken(credential))
+	now := time.Now()
+	t.mu.Lock()
+	cached, found := t.keys[hash]
+	t.mu.Unlock()
+	if found && now.Sub(cached.fetched) < t.ttl {
+		return cached.apiKey, cached.err
+	}
+
+	apiKey, err := t.fetch(ctx, credential)
+	if err != nil && err != database.ErrInvalidToken && err != database.ErrTokenScope {
+		// Not cached so the lookup is retried once the database recovers
+		return "", err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now.Sub(t.lastEvicted) >= t.ttl {
+		for key, cached := range t.keys {
+			if now.Sub(cached.fetched) >= t.ttl {
+				delete(t.keys, key)
+			}
+		}
+		t.lastEvicted = now
+	}
+	t.keys[hash] = tokenKey{apiKey: apiKey, err: err, fetched: now}
+	return apiKey, err
+}
+
+func (t *tokenKeys) fetch(ctx context.Context, token string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	conn, err := t.pool.Acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Release()
+
+	return database.ResolveToken(ctx, conn.Conn(), token, database.ScopeIngest)
+}
+
+// tokenError returns the HTTP status, gRPC status code and message of the
+// response to a payload sent with a token that cannot be used to ingest.
+func tokenError(err error) (int, int, string) {
+	switch err {
+	case database.ErrInvalidToken:
+		return http.StatusUnauthorized, rpcUnauthenticated, "Invalid token."
+	case database.ErrTokenScope:
+		return http.StatusForbidden, rpcPermissionDenied, "Token does not have the ingest scope."
+	}
+	return http.StatusServiceUnavailable, rpcUnavailable, "Database unavailable."
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tom-draper/api-analytics/server/database"
+)
+
+func TestResolveAPIKey(t *testing.T) {
+	tokens := newTokenKeys(nil)
+	apiKey, err := tokens.resolve(context.Background(), "b56cbd92-1168-4d7b-8d94-0418da207908")
+	if err != nil || apiKey != "b56cbd92-1168-4d7b-8d94-0418da207908" {
+		t.Errorf("got %q (%v), expected API key unchanged", apiKey, err)
+	}
+}
+
+func TestResolveCachedToken(t *testing.T) {
+	tokens := newTokenKeys(nil)
+	now := time.Now()
+	tokens.keys[string(database.HashToken("aat_valid"))] = tokenKey{apiKey: "key", fetched: now}
+	tokens.keys[string(database.HashToken("aat_revoked"))] = tokenKey{err: database.ErrInvalidToken, fetched: now}
+
+	if apiKey, err := tokens.resolve(context.Background(), "aat_valid"); err != nil || apiKey != "key" {
+		t.Errorf("got %q (%v), expected key", apiKey, err)
+	}
+	if _, err := tokens.resolve(context.Background(), "aat_revoked"); err != database.ErrInvalidToken {
+		t.Errorf("got %v, expected invalid token", err)
+	}
+}
@@ -6,7 +6,7 @@ import (
 	"math/rand"
 	"net"
 	"net/http"
-	"strings"
+	"os"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -65,25 +65,19 @@ func getMonitoredURLs(conn *pgx.Conn) []database.MonitorRow {
 }
 
 func deleteExpiredPings(conn *pgx.Conn) {
-	query := fmt.Sprintf("DELETE FROM pings WHERE created_at < '%s';", time.Now().Add(-60*24*time.Hour).UTC().Format("2006-01-02T15:04:05-0700"))
-	_, err := conn.Exec(context.Background(), query)
+	query := "DELETE FROM pings WHERE created_at < $1;"
+	_, err := conn.Exec(context.Background(), query, time.Now().Add(-60*24*time.Hour).UTC())
 	if err != nil {
 		panic(err)
 	}
 }
 
 func uploadPings(pings []database.PingsRow, conn *pgx.Conn) {
-	var query strings.Builder
-	query.WriteString("INSERT INTO pings (api_key, url, response_time, status, created_at) VALUES")
-	for i, ping := range pings {
-		if i > 0 {
-			query.WriteString(",")
-		}
-		query.WriteString(fmt.Sprintf(" ('%s', '%s', %d, %d, '%s')", ping.APIKey, ping.URL, ping.ResponseTime, ping.Status, ping.CreatedAt.UTC().Format("2006-01-02T15:04:05-0700")))
-	}
-	query.WriteString(";")
-
-	_, err := conn.Exec(context.Background(), query.String())
+	columns := []string{"api_key", "url", "response_time", "status", "created_at"}
+	_, err := conn.CopyFrom(context.Background(), pgx.Identifier{"pings"}, columns, pgx.CopyFromSlice(len(pings), func(i int) ([]any, error) {
+		ping := pings[i]
+		return []any{ping.APIKey, ping.URL, ping.ResponseTime, ping.Status, ping.CreatedAt.UTC()}, nil
+	}))
 	if err != nil {
 		panic(err)
 	}
@@ -128,15 +122,26 @@ func getClient() http.Client {
 }
 
 func main() {
-	conn := database.NewConnection()
-	defer conn.Close(context.Background())
+	pool, err := database.NewPool(context.Background(), database.NewPoolConfig())
+	if err != nil {
+		panic(err)
+	}
+	defer pool.Close()
+
+	conn, err := pool.Acquire(context.Background())
+	if err != nil {
+		// Database unreachable, skip this run
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	defer conn.Release()
 
-	monitored := getMonitoredURLs(conn)
+	monitored := getMonitoredURLs(conn.Conn())
 	// Shuffle URLs to ping to avoid a page looking consistently slow or fast
 	// due to cold starts or caching
 	shuffle(monitored)
 
 	pings := pingMonitored(monitored)
-	uploadPings(pings, conn)
-	deleteExpiredPings(conn)
+	uploadPings(pings, conn.Conn())
+	deleteExpiredPings(conn.Conn())
 }
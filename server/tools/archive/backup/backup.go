@@ -19,6 +19,7 @@ type UserRow struct {
 
 type RequestRow struct {
 	database.RequestRow
+	ResponseTime int32 // Microseconds, too wide for database.RequestRow
 }
 
 type MonitorRow struct {
@@ -197,7 +198,7 @@ func makeRequestsBackup(dirname string, requests map[string][]RequestRow) {
 		w.Comma = '|'
 		defer w.Flush()
 
-		data := [][]string{{"request_id", "api_key", "path", "hostname", "ip_address", "location", "user_agent", "method", "status", "response_time", "framework", "created_at"}}
+		data := [][]string{{"request_id", "api_key", "path", "hostname", "ip_address", "location", "user_agent", "method", "status", "response_time_us", "framework", "created_at"}}
 		for _, row := range rows {
 			row := []string{strconv.Itoa(row.RequestID), row.APIKey, row.Path, row.Hostname.String, row.IPAddress.String, row.Location.String, row.UserAgent.String, strconv.FormatInt(int64(row.Method), 10), strconv.FormatInt(int64(row.Status), 10), strconv.FormatInt(int64(row.ResponseTime), 10), strconv.FormatInt(int64(row.Framework), 10), row.CreatedAt.Format(time.RFC3339)}
 			data = append(data, row)
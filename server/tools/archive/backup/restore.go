@@ -75,7 +75,7 @@ type UserRows []UserRow
 func (r *UserRow) Parse(row []string) error {
 	r.UserID = row[0]
 	r.APIKey = row[1]
-	createdAt, err := time.Parse(row[2], time.RFC3339)
+	createdAt, err := time.Parse(time.RFC3339, row[2])
 	r.CreatedAt = createdAt
 	return err
 }
@@ -105,17 +105,17 @@ func (r *RequestRow) Parse(row []string) error {
 		return err
 	}
 	r.Status = int16(status)
-	responseTime, err := strconv.ParseInt(row[9], 10, 16)
+	responseTime, err := strconv.ParseInt(row[9], 10, 32) // Microseconds
 	if err != nil {
 		return err
 	}
-	r.ResponseTime = int16(responseTime)
+	r.ResponseTime = int32(responseTime)
 	framework, err := strconv.ParseInt(row[10], 10, 16)
 	if err != nil {
 		return err
 	}
 	r.Framework = int16(framework)
-	createdAt, err := time.Parse(row[11], time.RFC3339)
+	createdAt, err := time.Parse(time.RFC3339, row[11])
 	r.CreatedAt = createdAt
 	return err
 }
@@ -133,7 +133,7 @@ func (r *MonitorRow) Parse(row []string) error {
 		return err
 	}
 	r.Ping = ping
-	createdAt, err := time.Parse(row[4], time.RFC3339)
+	createdAt, err := time.Parse(time.RFC3339, row[4])
 	r.CreatedAt = createdAt
 	return err
 }
@@ -151,7 +151,7 @@ func (r *PingsRow) Parse(row []string) error {
 		return err
 	}
 	r.Status = status
-	createdAt, err := time.Parse(row[4], time.RFC3339)
+	createdAt, err := time.Parse(time.RFC3339, row[4])
 	r.CreatedAt = createdAt
 	return err
 }
@@ -164,6 +164,18 @@ func parseRows(file string, table string) ([]RestoreRow, error) {
 	defer f.Close()
 
 	reader := csv.NewReader(f)
+	reader.Comma = '|'
+
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			err = nil
+		}
+		return nil, err
+	}
+	// Backups taken before response times were stored in microseconds hold
+	// milliseconds
+	legacyResponseTime := table == "requests" && len(header) > 9 && header[9] == "response_time"
 
 	rows := []RestoreRow{}
 	for {
@@ -190,6 +202,9 @@ func parseRows(file string, table string) ([]RestoreRow, error) {
 		if err != nil {
 			panic(err)
 		}
+		if request, ok := r.(*RequestRow); ok && legacyResponseTime {
+			request.ResponseTime *= 1000
+		}
 		rows = append(rows, r)
 	}
 }
@@ -234,7 +249,7 @@ func insertRequestsData(db *sql.DB, rows []RequestRow) error {
 		fmtLocation := nullInsertString(request.Location)
 		fmtUserAgent := nullInsertString(request.UserAgent)
 
-		query.WriteString(fmt.Sprintf(" ('%s', %d, '%s', '%s', %d, %d, %d, %s, %s, %s)", request.APIKey, request.Method, request.CreatedAt.UTC().Format(time.RFC3339), request.Path, request.Status, request.ResponseTime, request.Framework, fmtUserAgent, fmtHostname, fmtIPAddress, fmtLocation))
+		query.WriteString(fmt.Sprintf(" ('%s', %d, '%s', '%s', %d, %d, %d, %s, %s, %s, %s)", request.APIKey, request.Method, request.CreatedAt.UTC().Format(time.RFC3339), request.Path, request.Status, request.ResponseTime, request.Framework, fmtUserAgent, fmtHostname, fmtIPAddress, fmtLocation))
 
 		if (i+1)%blockSize == 0 || i == len(rows)-1 {
 			query.WriteString(";")
@@ -317,14 +332,19 @@ func RestoreRequests(dirname string, dbName string) {
 	rows := readTable(dirname, "requests")
 	db := database.OpenDBConnectionNamed(dbName)
 	database.CreateRequestsTable(db)
-	var r []database.RequestRow
+	// Widen the column created by database.CreateRequestsTable to hold
+	// microseconds
+	if _, err := db.Exec("ALTER TABLE requests ALTER COLUMN response_time TYPE integer;"); err != nil {
+		panic(err)
+	}
+	var r []RequestRow
 	for _, val := range rows {
 		switch v := val.(type) {
 		case *RequestRow:
-			r = append(r, v.RequestRow)
+			r = append(r, *v)
 		}
 	}
-	database.InsertRequestsData(db, r)
+	insertRequestsData(db, r)
 }
 
 func RestoreMonitor(dirname string, dbName string) {
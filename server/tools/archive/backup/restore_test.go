@@ -2,6 +2,7 @@ package main
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -50,3 +51,30 @@ func TestRestore(t *testing.T) {
 	backupDir := diff[0]
 	Restore(backupDir, "test")
 }
+
+func TestParseRequestsResponseTime(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected int32
+	}{
+		{"response_time_us", 12},
+		{"response_time", 12000}, // Milliseconds in older backups
+	}
+
+	for i, test := range tests {
+		file := filepath.Join(t.TempDir(), "requests.csv")
+		data := "request_id|api_key|path|hostname|ip_address|location|user_agent|method|status|" + test.header + "|framework|created_at\n" +
+			"1|b56cbd92-1168-4d7b-8d94-0418da207908|/|||||0|200|12|0|2024-01-02T15:04:05Z\n"
+		if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		rows, err := parseRows(file, "requests")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rows[0].(*RequestRow).ResponseTime; got != test.expected {
+			t.Errorf("%d: got %d, expected %d", i, got, test.expected)
+		}
+	}
+}
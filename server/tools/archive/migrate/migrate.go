@@ -148,10 +148,12 @@ func MigrateSupabaseRequests() {
 			query = strings.Builder{}
 			query.WriteString("INSERT INTO requests (api_key, method, created_at, path, status, response_time, framework, hostname, ip_address, location) VALUES")
 		}
+		// Supabase stored milliseconds but requests now stores microseconds
+		responseTime := int32(request.ResponseTime) * 1000
 		if request.IPAddress == "" || request.IPAddress == "testclient" {
-			query.WriteString(fmt.Sprintf(" ('%s', %d, '%s', '%s', %d, %d, %d, '%s', NULL, '%s')", request.APIKey, request.Method, request.CreatedAt.UTC().Format(time.RFC3339), request.Path, request.Status, request.ResponseTime, request.Framework, request.Hostname, request.Location))
+			query.WriteString(fmt.Sprintf(" ('%s', %d, '%s', '%s', %d, %d, %d, '%s', NULL, '%s')", request.APIKey, request.Method, request.CreatedAt.UTC().Format(time.RFC3339), request.Path, request.Status, responseTime, request.Framework, request.Hostname, request.Location))
 		} else {
-			query.WriteString(fmt.Sprintf(" ('%s', %d, '%s', '%s', %d, %d, %d, '%s', '%s', '%s')", request.APIKey, request.Method, request.CreatedAt.UTC().Format(time.RFC3339), request.Path, request.Status, request.ResponseTime, request.Framework, request.Hostname, request.IPAddress, request.Location))
+			query.WriteString(fmt.Sprintf(" ('%s', %d, '%s', '%s', %d, %d, %d, '%s', '%s', '%s')", request.APIKey, request.Method, request.CreatedAt.UTC().Format(time.RFC3339), request.Path, request.Status, responseTime, request.Framework, request.Hostname, request.IPAddress, request.Location))
 		}
 
 		i++
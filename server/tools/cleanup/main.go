@@ -91,26 +91,45 @@ func deleteUser(apiKey string) {
 		return
 	}
 
-	err = database.DeleteUser(apiKey)
+	ctx := context.Background()
+	pool, err := database.NewPool(ctx, database.NewPoolConfig())
+	if err != nil {
+		panic(err)
+	}
+	defer pool.Close()
+
+	poolConn, err := pool.Acquire(ctx)
+	if err != nil {
+		panic(err)
+	}
+	defer poolConn.Release()
+	conn := poolConn.Conn()
+
+	err = database.DeleteUser(ctx, conn, apiKey)
 	if err != nil {
 		panic(err)
 	}
 	fmt.Println("User from table 'users'.")
-	err = database.DeleteRequests(apiKey)
+	err = database.DeleteRequests(ctx, conn, apiKey)
 	if err != nil {
 		panic(err)
 	}
 	fmt.Println("User from table 'requests'.")
-	err = database.DeleteMonitors(apiKey)
+	err = database.DeleteMonitors(ctx, conn, apiKey)
 	if err != nil {
 		panic(err)
 	}
 	fmt.Println("User from table 'monitors'.")
-	err = database.DeletePings(apiKey)
+	err = database.DeletePings(ctx, conn, apiKey)
 	if err != nil {
 		panic(err)
 	}
 	fmt.Println("User from table 'pings'.")
+	err = database.DeleteTokens(ctx, conn, apiKey)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("User from table 'tokens'.")
 
 	fmt.Println("User deletion successful.")
 }
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Preset log formats, written with nginx-style variables. Apache's combined
+// and common formats produce the same lines as their nginx equivalents.
+var presets = map[string]string{
+	"combined": `$remote_addr $ident $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`,
+	"common":   `$remote_addr $ident $remote_user [$time_local] "$request" $status $body_bytes_sent`,
+	"alb":      `$type $time $elb $client $target $request_processing_time $target_processing_time $response_processing_time $elb_status_code $target_status_code $received_bytes $sent_bytes "$request" "$http_user_agent" $ssl_cipher $ssl_protocol $target_group_arn "$trace_id" "$domain_name" $rest`,
+}
+
+// Framework recorded for requests imported with preset formats only written
+// by one server. The combined and common formats are written by both nginx and
+// Apache, so the framework must be given.
+var presetFrameworks = map[string]string{
+	"alb": "AWS ALB",
+}
+
+var variablePattern = regexp.MustCompile(`\$[a-z0-9_]+`)
+
+var errNoMatch = errors.New("line does not match log format")
+
+// Format parses access log lines written with a log format string. Each
+// $variable in the format captures a field, and the text between variables
+// must appear literally in the line.
+type Format struct {
+	pattern   *regexp.Regexp
+	variables []string
+}
+
+// NewFormat compiles a log format string, or the format string of a preset
+// if a preset name is given.
+func NewFormat(format string) (*Format, error) {
+	if preset, ok := presets[format]; ok {
+		format = preset
+	}
+
+	var pattern strings.Builder
+	var variables []string
+	pattern.WriteString("^")
+	matches := variablePattern.FindAllStringIndex(format, -1)
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("log format %q contains no variables", format)
+	}
+	end := 0
+	for i, match := range matches {
+		if i > 0 && match[0] == end {
+			return nil, fmt.Errorf("log format variables %s and %s must be separated", variables[i-1], format[match[0]:match[1]])
+		}
+		pattern.WriteString(regexp.QuoteMeta(format[end:match[0]]))
+		if i == len(matches)-1 && match[1] == len(format) {
+			pattern.WriteString("(.*)") // Final field takes the rest of the line
+		} else {
+			pattern.WriteString("(.*?)")
+		}
+		variables = append(variables, format[match[0]+1:match[1]])
+		end = match[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(format[end:]))
+	pattern.WriteString("$")
+
+	compiled, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return nil, err
+	}
+	return &Format{pattern: compiled, variables: variables}, nil
+}
+
+// Entry holds the request fields read from an access log line. Fields not
+// present in the log format are left empty, and sizes are -1 if unknown.
+type Entry struct {
+	Hostname     string
+	IPAddress    string
+	Path         string
+	UserAgent    string
+	Method       string
+	Protocol     string
+	Status       int
+	ResponseTime time.Duration
+	CreatedAt    time.Time
+	RequestSize  int64
+	ResponseSize int64
+	TLS          bool
+}
+
+// Parse reads the request fields from a single log line. Variables that do
+// not correspond to a requests column are ignored.
+func (f *Format) Parse(line string) (Entry, error) {
+	entry := Entry{RequestSize: -1, ResponseSize: -1}
+	match := f.pattern.FindStringSubmatch(line)
+	if match == nil {
+		return entry, errNoMatch
+	}
+
+	for i, variable := range f.variables {
+		value := match[i+1]
+		if value == "-" {
+			continue
+		}
+
+		var err error
+		switch variable {
+		case "remote_addr", "client":
+			entry.IPAddress = stripPort(value)
+		case "host", "http_host", "server_name", "domain_name":
+			entry.Hostname = stripPort(value)
+		case "time_local":
+			entry.CreatedAt, err = time.Parse("02/Jan/2006:15:04:05 -0700", value)
+		case "time_iso8601", "time":
+			entry.CreatedAt, err = time.Parse(time.RFC3339Nano, value)
+		case "msec":
+			var seconds float64
+			seconds, err = strconv.ParseFloat(value, 64)
+			entry.CreatedAt = time.UnixMicro(int64(seconds * 1e6))
+		case "request":
+			err = entry.setRequestLine(value)
+		case "request_method":
+			entry.Method = value
+		case "request_uri", "uri":
+			entry.setTarget(value)
+		case "server_protocol":
+			entry.Protocol = value
+		case "status", "elb_status_code":
+			entry.Status, err = strconv.Atoi(value)
+		case "request_time", "request_processing_time", "target_processing_time", "response_processing_time":
+			var seconds float64
+			seconds, err = strconv.ParseFloat(value, 64)
+			// ALB records -1 for stages that did not complete
+			if seconds > 0 {
+				entry.ResponseTime += time.Duration(seconds * float64(time.Second))
+			}
+		case "request_length", "received_bytes":
+			entry.RequestSize, err = strconv.ParseInt(value, 10, 64)
+		case "body_bytes_sent", "bytes_sent", "sent_bytes":
+			entry.ResponseSize, err = strconv.ParseInt(value, 10, 64)
+		case "http_user_agent":
+			entry.UserAgent = unescape(value)
+		case "scheme":
+			entry.TLS = entry.TLS || value == "https"
+		case "https":
+			entry.TLS = entry.TLS || value == "on"
+		case "type":
+			entry.TLS = entry.TLS || value == "https" || value == "h2" || value == "wss"
+		}
+		if err != nil {
+			return entry, fmt.Errorf("invalid %s: %w", variable, err)
+		}
+	}
+	return entry, nil
+}
+
+// setRequestLine reads the method, target and protocol from a request line,
+// e.g. "GET /index.html HTTP/1.1".
+func (e *Entry) setRequestLine(line string) error {
+	parts := strings.Split(line, " ")
+	if len(parts) != 3 {
+		return fmt.Errorf("malformed request line %q", line)
+	}
+	e.Method = parts[0]
+	e.setTarget(parts[1])
+	e.Protocol = parts[2]
+	return nil
+}
+
+// setTarget reads the path from a request target, along with the hostname
+// and scheme if it is an absolute URL as recorded by load balancers.
+func (e *Entry) setTarget(target string) {
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		if u, err := url.Parse(target); err == nil {
+			e.Hostname = u.Hostname()
+			e.TLS = e.TLS || u.Scheme == "https"
+			e.Path = u.EscapedPath()
+			if e.Path == "" {
+				e.Path = "/"
+			}
+			return
+		}
+	}
+	if i := strings.IndexByte(target, '?'); i >= 0 {
+		target = target[:i]
+	}
+	e.Path = target
+}
+
+// stripPort removes the port from a host:port value, leaving values without
+// a port unchanged.
+func stripPort(value string) string {
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		return host
+	}
+	return value
+}
+
+// unescape reverses the escaping of quotes and backslashes in quoted fields
+// written by nginx (\x22) and Apache (\").
+func unescape(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	return strings.NewReplacer(`\x22`, `"`, `\"`, `"`, `\x5C`, `\`, `\\`, `\`).Replace(value)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCombined(t *testing.T) {
+	format, err := NewFormat("combined")
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := `203.0.113.7 - frank [10/Oct/2023:13:55:36 +0100] "GET /api/users?page=2 HTTP/1.1" 200 2326 "https://example.com/" "Mozilla/5.0 (X11; Linux x86_64) \"quoted\""`
+	entry, err := format.Parse(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Entry{
+		IPAddress:    "203.0.113.7",
+		Path:         "/api/users",
+		UserAgent:    `Mozilla/5.0 (X11; Linux x86_64) "quoted"`,
+		Method:       "GET",
+		Protocol:     "HTTP/1.1",
+		Status:       200,
+		CreatedAt:    time.Date(2023, 10, 10, 12, 55, 36, 0, time.UTC),
+		RequestSize:  -1,
+		ResponseSize: 2326,
+	}
+	if !entry.CreatedAt.Equal(expected.CreatedAt) {
+		t.Errorf("got created at %s, expected %s", entry.CreatedAt, expected.CreatedAt)
+	}
+	entry.CreatedAt = expected.CreatedAt
+	if entry != expected {
+		t.Errorf("got %+v, expected %+v", entry, expected)
+	}
+}
+
+func TestParseCommon(t *testing.T) {
+	format, err := NewFormat("common")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := format.Parse(`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "POST /apache_pb.gif HTTP/1.0" 201 -`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Method != "POST" || entry.Path != "/apache_pb.gif" || entry.Status != 201 || entry.ResponseSize != -1 {
+		t.Errorf("got %+v", entry)
+	}
+}
+
+func TestParseALB(t *testing.T) {
+	format, err := NewFormat("alb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := `https 2023-07-15T23:39:43.945958Z app/my-loadbalancer/50dc6c495c0c9188 192.168.131.39:2817 10.0.0.1:80 0.086 0.048 0.037 200 200 0 57 "GET https://www.example.com:443/orders?id=1 HTTP/1.1" "curl/7.46.0" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2 arn:aws:elasticloadbalancing:us-east-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067 "Root=1-58337281-1d84f3d73c47ec4e58577259" "www.example.com" "arn:aws:acm:us-east-2:123456789012:certificate/12345678-1234-1234-1234-123456789012" 1 2023-07-15T23:39:43.758000Z "forward" "-" "-" "10.0.0.1:80" "200" "-" "-"`
+	entry, err := format.Parse(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Entry{
+		Hostname:     "www.example.com",
+		IPAddress:    "192.168.131.39",
+		Path:         "/orders",
+		UserAgent:    "curl/7.46.0",
+		Method:       "GET",
+		Protocol:     "HTTP/1.1",
+		Status:       200,
+		ResponseTime: 171 * time.Millisecond,
+		CreatedAt:    time.Date(2023, 7, 15, 23, 39, 43, 945958000, time.UTC),
+		RequestSize:  0,
+		ResponseSize: 57,
+		TLS:          true,
+	}
+	if !entry.CreatedAt.Equal(expected.CreatedAt) {
+		t.Errorf("got created at %s, expected %s", entry.CreatedAt, expected.CreatedAt)
+	}
+	entry.CreatedAt = expected.CreatedAt
+	if entry != expected {
+		t.Errorf("got %+v, expected %+v", entry, expected)
+	}
+}
+
+func TestParseCustom(t *testing.T) {
+	format, err := NewFormat(`$time_iso8601|$host|$request_method|$uri|$status|$request_time|$scheme`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := format.Parse(`2024-03-01T10:00:00+00:00|api.example.com:8443|DELETE|/items/4|204|0.250|https`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Hostname != "api.example.com" || entry.Method != "DELETE" || entry.Path != "/items/4" ||
+		entry.Status != 204 || entry.ResponseTime != 250*time.Millisecond || !entry.TLS {
+		t.Errorf("got %+v", entry)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	format, err := NewFormat("combined")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := []string{
+		"",
+		"not an access log line",
+		`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.1" ok 0 "-" "-"`,
+		`127.0.0.1 - - [yesterday] "GET / HTTP/1.1" 200 0 "-" "-"`,
+		`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "\x16\x03\x01" 400 0 "-" "-"`,
+	}
+	for i, line := range lines {
+		if _, err := format.Parse(line); err == nil {
+			t.Errorf("%d: expected error for %q", i, line)
+		}
+	}
+}
+
+func TestNewFormat(t *testing.T) {
+	tests := []struct {
+		format   string
+		expected bool
+	}{
+		{"combined", true},
+		{"common", true},
+		{"alb", true},
+		{`$remote_addr [$time_local] "$request" $status`, true},
+		{"no variables", false},
+		{"$remote_addr$status", false},
+	}
+
+	for i, test := range tests {
+		_, err := NewFormat(test.format)
+		if got := err == nil; got != test.expected {
+			t.Errorf("%d: got %t, expected %t", i, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+module github.com/tom-draper/api-analytics/server/tools/importer
+
+go 1.21
+
+require (
+	github.com/jackc/pgx/v5 v5.6.0
+	github.com/tom-draper/api-analytics/server/database v0.0.0-20240704162004-59effaf2e7c7
+	github.com/tom-draper/api-analytics/server/logger v0.0.0-00010101000000-000000000000
+)
+
+// GeoLite2 lookups shared with the logger, which is not published separately
+replace github.com/tom-draper/api-analytics/server/logger => ../../logger
+
+require (
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20240606120523-5a60cdf6a761 // indirect
+	github.com/joho/godotenv v1.5.1 // indirect
+	github.com/lib/pq v1.10.9 // indirect
+	github.com/oschwald/geoip2-golang v1.11.0 // indirect
+	github.com/oschwald/maxminddb-golang v1.13.1 // indirect
+	golang.org/x/crypto v0.25.0 // indirect
+	golang.org/x/sys v0.22.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+)
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/tom-draper/api-analytics/server/database"
+	"github.com/tom-draper/api-analytics/server/logger/lib/geo"
+)
+
+// Reasons for rejecting log lines in addition to those the logger reports
+const (
+	rejectUnparsed      string = "unparsed"
+	rejectInvalidStatus string = "invalid_status"
+)
+
+const defaultBatchSize int = 2000
+
+// Number of lines read between progress updates
+const progressInterval int = 10000
+
+var requestColumns = []string{"api_key", "path", "hostname", "ip_address", "status", "response_time", "method", "framework", "location", "user_id", "created_at", "weight", "request_size", "response_size", "protocol", "tls", "region", "city", "asn", "isp", "user_agent_id"}
+
+// row is a validated log entry waiting to be written.
+type row struct {
+	path         string
+	hostname     string
+	ipAddress    any    // netip.Addr, or nil if discarded for privacy or invalid
+	lookupIP     string // IP address the location is inferred from, if stored
+	status       int16
+	responseTime int32
+	method       int16
+	createdAt    time.Time
+	requestSize  any
+	responseSize any
+	protocol     any
+	tls          bool
+	userAgent    string
+}
+
+// newRow validates a log entry with the same rules the logger applies to
+// logged requests, returning the reason it was rejected if invalid.
+func newRow(entry Entry, options Options) (row, string) {
+	fields := database.RequestFields{Method: entry.Method, UserAgent: entry.UserAgent, Hostname: entry.Hostname, Path: entry.Path}
+	if fields.Hostname == "" {
+		fields.Hostname = options.hostname
+	}
+	method, reason := database.ValidateRequestFields(&fields)
+	if reason != "" {
+		return row{}, reason
+	}
+
+	if !database.ValidStatus(entry.Status) {
+		return row{}, rejectInvalidStatus
+	}
+	if !database.ValidDate(entry.CreatedAt) {
+		return row{}, database.RejectInvalidDate
+	}
+
+	// Location inferred from IP and stored for privacy level P1 and P2, and
+	// the IP address itself only stored for P1
+	var ipAddress any
+	var lookupIP string
+	if addr, err := netip.ParseAddr(entry.IPAddress); err == nil && options.privacyLevel < 2 {
+		lookupIP = entry.IPAddress
+		if options.privacyLevel == 0 {
+			ipAddress = addr.WithZone("")
+		}
+	}
+
+	// Body sizes are unknown if negative, protocol unknown if not recognised
+	var requestSize, responseSize, protocol any
+	if entry.RequestSize >= 0 {
+		requestSize = entry.RequestSize
+	}
+	if entry.ResponseSize >= 0 {
+		responseSize = entry.ResponseSize
+	}
+	if id, ok := database.ProtocolID[entry.Protocol]; ok {
+		protocol = id
+	}
+
+	responseTime := entry.ResponseTime.Microseconds()
+	if responseTime > math.MaxInt32 {
+		responseTime = math.MaxInt32
+	}
+
+	return row{
+		path:         fields.Path,
+		hostname:     fields.Hostname,
+		ipAddress:    ipAddress,
+		lookupIP:     lookupIP,
+		status:       int16(entry.Status),
+		responseTime: int32(responseTime),
+		method:       method,
+		createdAt:    entry.CreatedAt,
+		requestSize:  requestSize,
+		responseSize: responseSize,
+		protocol:     protocol,
+		tls:          entry.TLS,
+		userAgent:    fields.UserAgent,
+	}, ""
+}
+
+// importer reads log files and writes their valid entries in batches,
+// counting the lines rejected for each reason.
+type importer struct {
+	conn      *pgx.Conn // Nil for a dry run
+	locator   *geo.Locator
+	apiKey    pgtype.UUID
+	options   Options
+	format    *Format
+	framework int16
+
+	rows     []row
+	lines    int
+	imported int
+	rejected map[string]int
+	samples  int
+}
+
+func (im *importer) importFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		im.lines++
+		if err := im.importLine(line); err != nil {
+			return err
+		}
+		if im.lines%progressInterval == 0 {
+			im.displayProgress(path)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := im.flush(); err != nil {
+		return err
+	}
+	im.displayProgress(path)
+	fmt.Fprintln(os.Stderr)
+	return nil
+}
+
+func (im *importer) importLine(line string) error {
+	entry, err := im.format.Parse(line)
+	if err != nil {
+		im.rejected[rejectUnparsed]++
+		return nil
+	}
+	r, reason := newRow(entry, im.options)
+	if reason != "" {
+		im.rejected[reason]++
+		return nil
+	}
+
+	if im.options.dryRun && im.samples < 5 {
+		fmt.Printf("%s %s %s%s %d %dµs %s\n", r.createdAt.Format(time.RFC3339), entry.Method, r.hostname, r.path, r.status, r.responseTime, r.userAgent)
+		im.samples++
+	}
+
+	im.rows = append(im.rows, r)
+	if len(im.rows) >= im.options.batchSize {
+		return im.flush()
+	}
+	return nil
+}
+
+// flush writes the buffered rows to the requests table, storing any new user
+// agents first. Rows are only counted for a dry run.
+func (im *importer) flush() error {
+	if len(im.rows) == 0 {
+		return nil
+	}
+	if im.conn == nil {
+		im.imported += len(im.rows)
+		im.rows = im.rows[:0]
+		return nil
+	}
+
+	ctx := context.Background()
+	uniqueUserAgents := map[string]struct{}{}
+	for _, r := range im.rows {
+		uniqueUserAgents[r.userAgent] = struct{}{}
+	}
+	userAgentIDs, err := database.StoreUserAgents(ctx, im.conn, uniqueUserAgents)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]any, 0, len(im.rows))
+	for _, r := range im.rows {
+		var location geo.Location
+		if r.lookupIP != "" {
+			location = im.locator.Lookup(r.lookupIP)
+		}
+		rows = append(rows, []any{
+			im.apiKey,
+			r.path,
+			r.hostname,
+			r.ipAddress,
+			r.status,
+			r.responseTime,
+			r.method,
+			im.framework,
+			location.Country,
+			"",
+			r.createdAt,
+			float32(1),
+			r.requestSize,
+			r.responseSize,
+			r.protocol,
+			r.tls,
+			nullString(location.Region),
+			nullString(location.City),
+			nullASN(location.ASN),
+			nullString(location.ISP),
+			userAgentIDs[r.userAgent],
+		})
+	}
+	_, err = im.conn.CopyFrom(ctx, pgx.Identifier{"requests"}, requestColumns, pgx.CopyFromRows(rows))
+	if err != nil {
+		return err
+	}
+	im.imported += len(im.rows)
+	im.rows = im.rows[:0]
+	return nil
+}
+
+func nullString(value string) any {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+func nullASN(asn uint) any {
+	if asn == 0 {
+		return nil
+	}
+	return int64(asn)
+}
+
+func (im *importer) totalRejected() int {
+	total := 0
+	for _, count := range im.rejected {
+		total += count
+	}
+	return total
+}
+
+func (im *importer) displayProgress(path string) {
+	fmt.Fprintf(os.Stderr, "\r%s: %d lines read, %d imported, %d rejected", path, im.lines, im.imported, im.totalRejected())
+}
+
+func (im *importer) displaySummary() {
+	verb := "imported"
+	if im.options.dryRun {
+		verb = "would be imported"
+	}
+	fmt.Printf("%d lines read, %d requests %s, %d rejected\n", im.lines, im.imported, verb, im.totalRejected())
+
+	reasons := make([]string, 0, len(im.rejected))
+	for reason := range im.rejected {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Printf("  %s: %d\n", reason, im.rejected[reason])
+	}
+}
+
+func apiKeyExists(conn *pgx.Conn, apiKey string) (bool, error) {
+	var exists bool
+	query := "SELECT EXISTS(SELECT 1 FROM users WHERE api_key = $1);"
+	err := conn.QueryRow(context.Background(), query, apiKey).Scan(&exists)
+	return exists, err
+}
+
+type Options struct {
+	apiKey       string
+	format       string
+	framework    string
+	hostname     string
+	privacyLevel int
+	batchSize    int
+	dryRun       bool
+	help         bool
+	files        []string
+}
+
+func getOptions(args []string) (Options, error) {
+	options := Options{format: "combined", batchSize: defaultBatchSize}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case "--dry-run":
+			options.dryRun = true
+			continue
+		case "--help":
+			options.help = true
+			continue
+		case "--api-key", "--format", "--framework", "--hostname", "--privacy-level", "--batch-size":
+		default:
+			options.files = append(options.files, arg)
+			continue
+		}
+
+		if i+1 >= len(args) {
+			return options, fmt.Errorf("%s requires a value", arg)
+		}
+		i++
+		value := args[i]
+		switch arg {
+		case "--api-key":
+			options.apiKey = value
+		case "--format":
+			options.format = value
+		case "--framework":
+			options.framework = value
+		case "--hostname":
+			options.hostname = value
+		case "--privacy-level":
+			level, err := strconv.Atoi(value)
+			if err != nil || level < 0 || level > 2 {
+				return options, fmt.Errorf("invalid privacy level %q", value)
+			}
+			options.privacyLevel = level
+		case "--batch-size":
+			size, err := strconv.Atoi(value)
+			if err != nil || size <= 0 {
+				return options, fmt.Errorf("invalid batch size %q", value)
+			}
+			options.batchSize = size
+		}
+	}
+
+	// Formats written by more than one server require the framework
+	if options.framework == "" {
+		options.framework = presetFrameworks[options.format]
+	}
+	if options.framework == "" && !options.help {
+		return options, fmt.Errorf("--framework required for the %s format", options.format)
+	}
+	return options, nil
+}
+
+func displayHelp() {
+	fmt.Printf("Importer - A command-line tool to import access log files as logged requests.\n\nUsage: importer --api-key <key> --framework <framework> [options] <file>...\n\nOptions:\n`--api-key` to specify the API key requests are imported under\n`--format` to specify a preset (combined, common, alb) or a log format string using nginx variables, defaults to combined\n`--framework` to specify the framework recorded (nginx, Apache, AWS ALB), required unless the format is alb\n`--hostname` to specify the hostname for log formats without one\n`--privacy-level` to specify the privacy level (0-2), IP addresses are discarded above 0 and locations above 1\n`--batch-size` to specify the number of requests written at a time\n`--dry-run` to parse and validate files without writing to the database\n`--help` to display help\n\nFiles ending in .gz are decompressed.\n")
+}
+
+func main() {
+	options, err := getOptions(os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
+	if options.help {
+		displayHelp()
+		return
+	}
+	if options.apiKey == "" || len(options.files) == 0 {
+		displayHelp()
+		os.Exit(1)
+	}
+
+	format, err := NewFormat(options.format)
+	if err != nil {
+		panic(err)
+	}
+	framework, ok := database.FrameworkID[options.framework]
+	if !ok {
+		panic(fmt.Sprintf("unsupported framework %q", options.framework))
+	}
+
+	var apiKey pgtype.UUID
+	if err := apiKey.Scan(options.apiKey); err != nil {
+		panic(fmt.Sprintf("invalid API key '%s'", options.apiKey))
+	}
+
+	im := &importer{
+		apiKey:    apiKey,
+		options:   options,
+		format:    format,
+		framework: framework,
+		rejected:  make(map[string]int),
+	}
+	if !options.dryRun {
+		ctx := context.Background()
+		pool, err := database.NewPool(ctx, database.NewPoolConfig())
+		if err != nil {
+			panic(err)
+		}
+		defer pool.Close()
+
+		conn, err := pool.Acquire(ctx)
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Release()
+
+		exists, err := apiKeyExists(conn.Conn(), options.apiKey)
+		if err != nil {
+			panic(err)
+		}
+		if !exists {
+			panic(fmt.Sprintf("API key '%s' not found", options.apiKey))
+		}
+		im.conn = conn.Conn()
+
+		// Location inferred from IP addresses as by the logger
+		im.locator = geo.NewLocator(geo.NewConfig())
+		defer im.locator.Close()
+	}
+
+	for _, path := range options.files {
+		if err := im.importFile(path); err != nil {
+			im.displaySummary()
+			panic(err)
+		}
+	}
+	im.displaySummary()
+}
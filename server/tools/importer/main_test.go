@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/tom-draper/api-analytics/server/database"
+)
+
+func TestNewRow(t *testing.T) {
+	valid := Entry{
+		IPAddress:    "203.0.113.7",
+		Path:         "/api/users",
+		UserAgent:    "curl/8.0",
+		Method:       "GET",
+		Protocol:     "HTTP/1.1",
+		Status:       200,
+		CreatedAt:    time.Now(),
+		RequestSize:  -1,
+		ResponseSize: 10,
+	}
+
+	tests := []struct {
+		modify   func(e *Entry)
+		expected string
+	}{
+		{func(e *Entry) {}, ""},
+		{func(e *Entry) { e.Method = "BREW" }, database.RejectInvalidMethod},
+		{func(e *Entry) { e.Hostname = "bad host" }, database.RejectInvalidHostname},
+		{func(e *Entry) { e.Status = 0 }, rejectInvalidStatus},
+		{func(e *Entry) { e.CreatedAt = time.Time{} }, database.RejectInvalidDate},
+		{func(e *Entry) { e.UserAgent = "curl\n/8.0" }, ""}, // Control characters removed
+	}
+
+	for i, test := range tests {
+		entry := valid
+		test.modify(&entry)
+		if _, got := newRow(entry, Options{hostname: "example.com"}); got != test.expected {
+			t.Errorf("%d: got %q, expected %q", i, got, test.expected)
+		}
+	}
+}
+
+func TestNewRowPrivacy(t *testing.T) {
+	entry := Entry{IPAddress: "203.0.113.7", Path: "/", Method: "GET", Status: 200, CreatedAt: time.Now(), Protocol: "HTTP/9", RequestSize: -1}
+
+	tests := []struct {
+		privacyLevel int
+		ipAddress    any
+		lookupIP     string
+	}{
+		{0, netip.MustParseAddr("203.0.113.7"), "203.0.113.7"},
+		{1, nil, "203.0.113.7"}, // Location still inferred
+		{2, nil, ""},
+	}
+
+	for i, test := range tests {
+		r, _ := newRow(entry, Options{privacyLevel: test.privacyLevel})
+		if r.ipAddress != test.ipAddress || r.lookupIP != test.lookupIP {
+			t.Errorf("%d: got IP address %v and lookup IP %q, expected %v and %q", i, r.ipAddress, r.lookupIP, test.ipAddress, test.lookupIP)
+		}
+		if r.protocol != nil || r.requestSize != nil {
+			t.Errorf("%d: got protocol %v and request size %v, expected nil", i, r.protocol, r.requestSize)
+		}
+	}
+}
+
+func TestGetOptions(t *testing.T) {
+	options, err := getOptions([]string{"--api-key", "key", "--format", "alb", "--dry-run", "a.log", "b.log.gz"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.apiKey != "key" || options.format != "alb" || options.framework != "AWS ALB" || !options.dryRun || len(options.files) != 2 {
+		t.Errorf("got %+v", options)
+	}
+
+	options, err = getOptions([]string{"--api-key", "key", "--framework", "Apache", "a.log"})
+	if err != nil || options.format != "combined" || options.framework != "Apache" {
+		t.Errorf("got %+v (%v)", options, err)
+	}
+
+	invalid := [][]string{
+		{"--api-key"},
+		{"--api-key", "key", "a.log"}, // Combined format written by nginx and Apache
+		{"--privacy-level", "3"},
+		{"--batch-size", "0"},
+	}
+	for i, args := range invalid {
+		if _, err := getOptions(args); err == nil {
+			t.Errorf("%d: expected error for %v", i, args)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package lib
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -33,7 +34,20 @@ func TryNewUser() error {
 		return fmt.Errorf("uuid value returned is invalid")
 	}
 
-	err = database.DeleteUser(apiKey)
+	ctx := context.Background()
+	pool, err := database.NewPool(ctx, database.NewPoolConfig())
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	err = database.DeleteUser(ctx, conn.Conn(), apiKey)
 	if err != nil {
 		return err
 	}
@@ -261,7 +261,7 @@ func AvgResponseTime() (float64, error) {
 	defer conn.Close(context.Background())
 
 	var avg float64
-	query := "SELECT AVG(response_time) FROM requests;"
+	query := "SELECT AVG(response_time) / 1000 FROM requests;" // Stored in microseconds
 	err := conn.QueryRow(context.Background(), query).Scan(&avg)
 	if err != nil {
 		return 0.0, err